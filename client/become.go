@@ -0,0 +1,253 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains privilege escalation (become) by sudo for the client commands.
+//
+// The command is wrapped by sudo with a prompt marker. When the marker is in the output
+// the password is fed through stdin (or the pseudo-terminal) of the command, and
+// the command prints a start marker before it runs. The markers are removed from
+// the output and stdin of the command is forwarded after the start marker.
+
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	becomeMarkPrompt = "[yapi-become-prompt-" + becomeNonce() + "]" // prompt marker of sudo
+	becomeMarkStart  = "[yapi-become-start-" + becomeNonce() + "]"  // start marker of the command
+	becomePw         string                                         // password from the terminal
+	becomePwMu       sync.Mutex                                     // mutex for the password
+)
+
+// becomeNonce returns a random hex string for the markers.
+func becomeNonce() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// becomeCmd returns the command which is wrapped by sudo for the given user.
+// sudo reads the password from stdin unless there is a pseudo-terminal.
+func becomeCmd(cliCmd, user string, isTTY bool) string {
+
+	sudoCmd := "sudo -p " + shellQuote(becomeMarkPrompt) + " -u " + shellQuote(user)
+	if isTTY == false {
+		sudoCmd += " -S"
+	}
+
+	return sudoCmd + " -- sh -c " + shellQuote("printf '%s' "+shellQuote(becomeMarkStart)+" >&2; "+cliCmd)
+}
+
+// becomePassword returns the become password by the given authentication information.
+// Sources; BecomePassword, BecomePasswordEnv environment variable and the terminal
+// (asked once for all the clients).
+func becomePassword(cliAuth ClientAuth) (string, error) {
+
+	if cliAuth.BecomePassword != "" {
+		return cliAuth.BecomePassword, nil
+	} else if cliAuth.BecomePasswordEnv != "" {
+		if val, ok := os.LookupEnv(cliAuth.BecomePasswordEnv); ok == true {
+			return val, nil
+		}
+		return "", errors.New("environment variable is not set: " + cliAuth.BecomePasswordEnv)
+	}
+
+	becomePwMu.Lock()
+	defer becomePwMu.Unlock()
+
+	if becomePw != "" {
+		return becomePw, nil
+	}
+
+	pw, err := ttyPassword("[sudo] password: ")
+	if err != nil {
+		return "", err
+	}
+	becomePw = pw
+
+	return pw, nil
+}
+
+// becomeState implements the state of a privilege escalation.
+type becomeState struct {
+	mu       sync.Mutex
+	stdin    io.WriteCloser         // stdin of the session
+	input    io.Reader              // stdin of the command
+	password func() (string, error) // password source
+	prompted bool                   // whether the password is fed or not
+	started  bool                   // whether the command is started or not
+	err      error                  // error of the escalation if any
+}
+
+// newBecome returns a new become state by the given session stdin, command stdin
+// and password source.
+func newBecome(stdin io.WriteCloser, input io.Reader, password func() (string, error)) *becomeState {
+	return &becomeState{
+		stdin:    stdin,
+		input:    input,
+		password: password,
+	}
+}
+
+// writer returns a writer which removes the markers from the output and handles them.
+func (bs *becomeState) writer(w io.Writer) *becomeWriter {
+	return &becomeWriter{w: w, state: bs}
+}
+
+// Err returns the error of the escalation if any.
+func (bs *becomeState) Err() error {
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	return bs.err
+}
+
+// prompt feeds the password. sudo prompts again if the password is incorrect, so stdin
+// is closed instead of feeding it again. It must be called by the lock, the lock is
+// released while getting the password (it may wait for the terminal).
+func (bs *becomeState) prompt() {
+
+	if bs.prompted == true {
+		bs.err = errors.New("become failed: incorrect password")
+		bs.stdin.Close()
+		return
+	}
+	bs.prompted = true
+
+	bs.mu.Unlock()
+	pw, err := bs.password()
+	bs.mu.Lock()
+
+	if err != nil {
+		bs.err = errors.New("become failed: failed to get the password: " + err.Error())
+		bs.stdin.Close()
+		return
+	}
+
+	io.WriteString(bs.stdin, pw+"\n")
+}
+
+// start forwards stdin of the command (if any). It must be called by the lock.
+func (bs *becomeState) start() {
+
+	bs.started = true
+
+	if bs.input == nil {
+		bs.stdin.Close()
+		return
+	}
+
+	go func() {
+		io.Copy(bs.stdin, bs.input)
+		bs.stdin.Close()
+	}()
+}
+
+// becomeWriter implements a writer which removes the become markers from the output.
+type becomeWriter struct {
+	w      io.Writer
+	state  *becomeState
+	buf    []byte // pending output (it may have a part of a marker)
+	skipNL bool   // whether the newline after the prompt is skipped or not
+}
+
+// Write writes the given bytes to the underlying writer without the markers.
+func (bw *becomeWriter) Write(p []byte) (int, error) {
+
+	bw.state.mu.Lock()
+	defer bw.state.mu.Unlock()
+
+	if bw.state.started == true && len(bw.buf) == 0 {
+		return bw.w.Write(p)
+	}
+	bw.buf = append(bw.buf, p...)
+
+	for len(bw.buf) > 0 {
+
+		// The output of the command is written as is
+		if bw.state.started == true {
+			if _, err := bw.w.Write(bw.buf); err != nil {
+				return 0, err
+			}
+			bw.buf, bw.skipNL = nil, false
+			break
+		}
+
+		// Skip the newline which sudo prints after reading the password
+		if bw.skipNL == true {
+			bw.buf = bytes.TrimLeft(bw.buf, "\r\n")
+			if len(bw.buf) == 0 {
+				break
+			}
+			bw.skipNL = false
+		}
+
+		// Find the markers
+		i, mark := bytes.Index(bw.buf, []byte(becomeMarkPrompt)), becomeMarkPrompt
+		if j := bytes.Index(bw.buf, []byte(becomeMarkStart)); j >= 0 && (i < 0 || j < i) {
+			i, mark = j, becomeMarkStart
+		}
+
+		if i < 0 {
+			n := len(bw.buf) - becomePartial(bw.buf)
+			if _, err := bw.w.Write(bw.buf[:n]); err != nil {
+				return 0, err
+			}
+			bw.buf = bw.buf[n:]
+			break
+		}
+
+		if _, err := bw.w.Write(bw.buf[:i]); err != nil {
+			return 0, err
+		}
+		bw.buf = bw.buf[i+len(mark):]
+
+		if mark == becomeMarkPrompt {
+			bw.state.prompt()
+			bw.skipNL = true
+		} else {
+			bw.state.start()
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes the pending output.
+func (bw *becomeWriter) Flush() {
+
+	bw.state.mu.Lock()
+	defer bw.state.mu.Unlock()
+
+	if len(bw.buf) > 0 {
+		bw.w.Write(bw.buf)
+		bw.buf = nil
+	}
+}
+
+// becomePartial returns the length of the longest suffix of the given bytes
+// which is a prefix of a marker.
+func becomePartial(buf []byte) int {
+
+	for n := len(becomeMarkPrompt) - 1; n > 0; n-- {
+		if n > len(buf) {
+			continue
+		}
+		suffix := buf[len(buf)-n:]
+		if bytes.HasPrefix([]byte(becomeMarkPrompt), suffix) == true || bytes.HasPrefix([]byte(becomeMarkStart), suffix) == true {
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,144 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStdin implements a session stdin for the become tests.
+type testStdin struct {
+	bytes.Buffer
+	closed bool
+}
+
+// Close closes the stdin.
+func (ts *testStdin) Close() error {
+	ts.closed = true
+	return nil
+}
+
+// TestBecomeWriter checks that the markers are removed from the output when they are
+// split between the writes.
+func TestBecomeWriter(t *testing.T) {
+
+	prompt, start := becomeMarkPrompt, becomeMarkStart
+	tests := []struct {
+		name    string
+		output  string // output of the command (split into the writes)
+		want    string
+		wantPw  bool // whether the password is fed or not
+		wantErr bool
+	}{
+		{name: "no marker", output: "out\n", want: "out\n"},
+		{name: "start", output: start + "out\n", want: "out\n"},
+		{name: "prompt and start", output: prompt + "\n" + start + "out\n", want: "out\n", wantPw: true},
+		{name: "prompt with CRLF", output: prompt + "\r\n" + start + "out\n", want: "out\n", wantPw: true},
+		{name: "output before", output: "motd\n" + prompt + "\n" + start + "out", want: "motd\nout", wantPw: true},
+		{name: "marker like output", output: "[yapi-become-x]" + start + "[yapi-become-", want: "[yapi-become-x][yapi-become-"},
+		{name: "incorrect password", output: prompt + "\n" + prompt, want: "", wantPw: true, wantErr: true},
+		{name: "markers after start", output: start + prompt, want: prompt},
+	}
+
+	for _, test := range tests {
+		for _, size := range []int{1, 2, 7, len(test.output)} {
+
+			stdin := new(testStdin)
+			state := newBecome(stdin, strings.NewReader(""), func() (string, error) {
+				return "pw", nil
+			})
+			out := new(bytes.Buffer)
+			bw := state.writer(out)
+
+			for i := 0; i < len(test.output); i += size {
+				end := i + size
+				if end > len(test.output) {
+					end = len(test.output)
+				}
+				if n, err := bw.Write([]byte(test.output[i:end])); err != nil || n != end-i {
+					t.Fatalf("%s (%d): write failed: %d %v", test.name, size, n, err)
+				}
+			}
+			bw.Flush()
+
+			if out.String() != test.want {
+				t.Errorf("%s (%d): got %q, want %q", test.name, size, out.String(), test.want)
+			}
+			if gotPw := strings.HasPrefix(stdin.String(), "pw\n"); gotPw != test.wantPw {
+				t.Errorf("%s (%d): password fed: %v, want %v", test.name, size, gotPw, test.wantPw)
+			}
+			if (state.Err() != nil) != test.wantErr {
+				t.Errorf("%s (%d): unexpected error: %v", test.name, size, state.Err())
+			}
+		}
+	}
+}
+
+// TestBecomePrompt checks that the other writers are not blocked while the password
+// is being got.
+func TestBecomePrompt(t *testing.T) {
+
+	pwC := make(chan string)
+	stdin := new(testStdin)
+	state := newBecome(stdin, nil, func() (string, error) {
+		return <-pwC, nil
+	})
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	outW, errW := state.writer(stdout), state.writer(stderr)
+
+	done := make(chan bool)
+	go func() {
+		errW.Write([]byte(becomeMarkPrompt))
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	wrote := make(chan bool)
+	go func() {
+		outW.Write([]byte("motd\n"))
+		close(wrote)
+	}()
+	select {
+	case <-wrote:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write is blocked by the password prompt")
+	}
+
+	pwC <- "pw"
+	<-done
+
+	if stdout.String() != "motd\n" {
+		t.Errorf("got %q, want %q", stdout.String(), "motd\n")
+	}
+	if stdin.String() != "pw\n" {
+		t.Errorf("got password %q, want %q", stdin.String(), "pw\n")
+	}
+}
+
+// TestBecomePartial checks the partial marker lengths.
+func TestBecomePartial(t *testing.T) {
+
+	tests := []struct {
+		buf  string
+		want int
+	}{
+		{"", 0},
+		{"out", 0},
+		{"out[", 1},
+		{"out[yapi-become-", len("[yapi-become-")},
+		{"out" + becomeMarkStart[:len(becomeMarkStart)-1], len(becomeMarkStart) - 1},
+		{"out" + becomeMarkPrompt, 0}, // complete markers are not partial
+		{"[yapi-x", 0},
+	}
+
+	for _, test := range tests {
+		if got := becomePartial([]byte(test.buf)); got != test.want {
+			t.Errorf("%q: got %d, want %d", test.buf, got, test.want)
+		}
+	}
+}
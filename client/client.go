@@ -7,8 +7,24 @@ package client
 
 import (
 	"code.google.com/p/go-uuid/uuid"
+	"context"
 	"errors"
+	"github.com/cmfatih/yapi/stdin"
+	"io"
+	"io/ioutil"
+	"os"
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ErrKindConnect  = "connect"  // error kind for connection errors
+	ErrKindExec     = "exec"     // error kind for execution errors
+	ErrKindTimeout  = "timeout"  // error kind for timed out executions
+	ErrKindCanceled = "canceled" // error kind for canceled executions
+	ErrKindSkipped  = "skipped"  // error kind for skipped executions (i.e. aborted by the worker)
 )
 
 var (
@@ -35,26 +51,97 @@ type Client interface {
 	// Kind returns the kind of the client.
 	Kind() string
 
+	// Addr returns the address information of the remote system.
+	Addr() string
+
 	// SetAddr sets the address information of the remote system.
 	SetAddr(cliAddr string) error
 
 	// SetAuth sets the authentication information of the remote system.
 	SetAuth(cliAuth ClientAuth) error
 
+	// SetOptions sets the options of the client.
+	SetOptions(cliOpts ClientOptions) error
+
 	// Connect establishes a connection to the remote system.
 	Connect() error
 
-	// ExecCmd executes the given command on the remote system.
-	ExecCmd(cliCmd string) (bool, error)
+	// Close closes the connection to the remote system.
+	Close() error
+
+	// Exec executes the given command on the remote system by the given options
+	// and returns the result. If the context is done then the remote command is
+	// stopped and the result is returned immediately.
+	Exec(ctx context.Context, cliCmd string, execOpts ExecOptions) Result
+
+	// Put uploads the given local file or directory (recursively) to the given
+	// remote path and returns the result.
+	Put(ctx context.Context, src, dst string) Result
+
+	// Get downloads the given remote file or directory (recursively) into the given
+	// local directory and returns the result.
+	Get(ctx context.Context, src, dst string) Result
 }
 
 // ClientAuth implements authentication info.
 // Username, Password and Keyfile are universal for authentication.
-// Consider other methods (ssh-agent, db, etc.) at the future.
+// Keyfiles are the additional key files. Passphrase (or the value of the environment
+// variable by PassphraseEnv) is used for the encrypted key files, otherwise
+// it is prompted on the terminal.
+// Agent is used by ssh clients for ssh-agent authentication (SSH_AUTH_SOCK).
+// DefaultKeyfiles is used by ssh clients for the default key files of the current user
+// (HOME/.ssh/id_ed25519, id_ecdsa and id_rsa) which are added after the IdentityFile
+// values of ssh_config as ssh does.
+// BecomePassword (or the value of the environment variable by BecomePasswordEnv) is
+// used for the privilege escalation (sudo), otherwise it is prompted on the terminal.
+// Consider other methods (db, etc.) at the future.
 type ClientAuth struct {
-	Username string
-	Password string
-	Keyfile  string
+	Username          string
+	Password          string
+	Keyfile           string
+	Keyfiles          []string
+	Passphrase        string
+	PassphraseEnv     string
+	Agent             bool
+	DefaultKeyfiles   bool
+	BecomePassword    string
+	BecomePasswordEnv string
+}
+
+// ClientOptions implements the options of the client.
+// HostKey, KnownHostsFile and HostKeyPolicy are used by ssh clients for host key
+// verification. HostKey can be a public key (i.e. `ssh-ed25519 AAAA...`) or
+// a SHA256 fingerprint (i.e. `SHA256:...`). HostKeyPolicy can be; strict (default),
+// accept-new or off. HostCAKey is a public key or a file of the host CA keys for the
+// host certificates.
+// ProxyJump is used by ssh clients for the jump hosts. It is comma separated list of
+// client names or `[user@]host[:port]` addresses.
+// IdleTimeout is used by ssh clients for closing the idle connections.
+// ConnectTimeout and HandshakeTimeout are used by ssh clients (negative values mean no timeout).
+// KeepaliveInterval and KeepaliveCountMax are used by ssh clients for detecting
+// the lost connections.
+// TTY is used by ssh clients for requesting a pseudo-terminal for all the commands.
+// Become is used by ssh clients for executing the commands as the given user by sudo.
+// Env (KEY=VALUE) and Workdir are the default environment variables and working
+// directory of the commands. The execution options take precedence.
+// SSHConfigFile is used by ssh clients for the host aliases and the default settings
+// (see sshconfig.go). `none` disables it.
+type ClientOptions struct {
+	HostKey           string   // host key of the remote system
+	KnownHostsFile    string   // known_hosts file (default; HOME/.ssh/known_hosts)
+	HostKeyPolicy     string   // host key policy
+	HostCAKey         string   // host CA key(s) of the host certificates
+	ProxyJump         string   // jump hosts
+	IdleTimeout       int64    // idle timeout of the connection in millisecond (default 60000)
+	ConnectTimeout    int64    // connect timeout in millisecond (default 10000)
+	HandshakeTimeout  int64    // handshake timeout in millisecond (default 60000)
+	KeepaliveInterval int64    // keepalive interval in millisecond (default 0, disabled)
+	KeepaliveCountMax int      // max number of the unanswered keepalives (default 3)
+	TTY               bool     // pseudo-terminal for the commands
+	Env               []string // environment variables of the commands (KEY=VALUE)
+	Workdir           string   // working directory of the commands
+	Become            string   // user of the commands by sudo (privilege escalation)
+	SSHConfigFile     string   // ssh_config file (default; HOME/.ssh/config and /etc/ssh/ssh_config)
 }
 
 // New returns a new client with the given kind and name.
@@ -105,6 +192,14 @@ func New(cliKind, cliName string) (Client, error) {
 	return nil, errors.New("unexpected error! (client.New)")
 }
 
+// CloseAll closes the connections of all the clients.
+func CloseAll() {
+	for _, cli := range clients {
+		cli.Close()
+	}
+	sshJumpCloseAll()
+}
+
 // ByID returns the client by the given id.
 func ByID(cliID string) (Client, error) {
 	if cliID == "" || clients[cliID] == nil {
@@ -142,30 +237,243 @@ func ByGroupName(cliGroupName string) []Client {
 	return nil
 }
 
+// ExecOptions implements the options of a command execution.
+// Stdin, Stdout and Stderr are optional. If Stdin is nil then the command has no input,
+// if Stdout or Stderr is nil then the output is discarded.
+// TTY requests a pseudo-terminal (by the size of the local terminal) for the command.
+// Stderr of the command is merged into Stdout by the pseudo-terminal.
+// Become executes the command as the given user by sudo (see become.go).
+// Script is a local script file which is uploaded and executed (by the interpreter of
+// its shebang line) with ScriptArgs instead of the command.
+type ExecOptions struct {
+	Stdin      io.Reader // stdin of the command
+	Stdout     io.Writer // stdout of the command
+	Stderr     io.Writer // stderr of the command
+	Env        []string  // environment variables of the command (KEY=VALUE)
+	Dir        string    // working directory of the command
+	TTY        bool      // pseudo-terminal for the command
+	Become     string    // user of the command by sudo
+	Script     string    // local script file
+	ScriptArgs []string  // arguments of the script
+}
+
+// HostExecOptions returns the execution options which use stdin (if there is a stream),
+// stdout and stderr of host. Every call returns its own reader of stdin (see input.go),
+// so it must be called for each execution.
+func HostExecOptions() ExecOptions {
+
+	execOpts := ExecOptions{
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	if stdin.StdinHasPipe() == true {
+		execOpts.Stdin = hostInputReader()
+	}
+
+	return execOpts
+}
+
+// Result implements the result of a client command execution.
+// Err is set if the command couldn't be executed and ErrKind tells whether it is
+// a connection error or an execution error. Otherwise ExitCode and Signal are
+// reported by the remote system.
+type Result struct {
+	Name     string        // client name
+	ExitCode int           // exit code of the command (-1 if it couldn't be determined)
+	Signal   string        // signal which terminated the command if any
+	Start    time.Time     // start time of the execution
+	End      time.Time     // end time of the execution
+	Duration time.Duration // duration of the execution
+	BytesOut int64         // number of bytes written to stdout (transferred bytes for file transfers)
+	BytesErr int64         // number of bytes written to stderr
+	Files    int           // number of the transferred files (file transfers)
+	ErrKind  string        // kind of the error if any; connect, exec, timeout, canceled, skipped
+	Err      error         // error if any
+}
+
+// Failed returns whether the command is failed or not.
+func (res *Result) Failed() bool {
+	return res.Err != nil || res.ExitCode != 0
+}
+
+// newResult returns a new result by the given client name.
+func newResult(cliName string) *Result {
+	return &Result{
+		Name:     cliName,
+		ExitCode: -1,
+		Start:    time.Now(),
+	}
+}
+
+// done completes the result by the given error kind and error.
+// If the error is completed with an exit status on the remote system then
+// the exit code and the signal are set instead of the error.
+func (res *Result) done(errKind string, err error) Result {
+
+	res.End = time.Now()
+	res.Duration = res.End.Sub(res.Start)
+
+	if err == nil {
+		res.ExitCode = 0
+	} else if es, ok := err.(exitStatuser); ok {
+		res.ExitCode = es.ExitStatus()
+		res.Signal = es.Signal()
+	} else {
+		res.ErrKind = errKind
+		res.Err = err
+	}
+
+	return *res
+}
+
+// exitStatuser is the interface that is implemented by the errors of the commands
+// those are completed with an exit status on the remote system (i.e. *ssh.ExitError).
+type exitStatuser interface {
+	ExitStatus() int
+	Signal() string
+}
+
+// countWriter implements a writer which counts the written bytes.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// newCountWriter returns a new count writer by the given writer.
+// If the writer is nil then the written bytes are discarded.
+func newCountWriter(w io.Writer) *countWriter {
+	if w == nil {
+		w = ioutil.Discard
+	}
+
+	return &countWriter{w: w}
+}
+
+// Write writes the given bytes to the underlying writer.
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddInt64(&cw.n, int64(n))
+	return n, err
+}
+
+// Count returns the number of the written bytes.
+func (cw *countWriter) Count() int64 {
+	return atomic.LoadInt64(&cw.n)
+}
+
+// ctxErr returns the error kind and the error by the given (done) context.
+func ctxErr(ctx context.Context) (string, error) {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrKindTimeout, errors.New("timeout")
+	} else if sig := CtxSignal(ctx); sig != "" {
+		return ErrKindCanceled, errors.New("interrupted (SIG" + sig + ")")
+	}
+
+	return ErrKindCanceled, errors.New("canceled")
+}
+
 // ExecCmd executes the given command on the remote system by the given client name.
-func ExecCmd(cliCmd, cliName string) error {
+// It uses stdin (if there is a stream), stdout and stderr of host.
+func ExecCmd(ctx context.Context, cliCmd, cliName string) Result {
+	return ExecCmdOpts(ctx, cliCmd, cliName, HostExecOptions())
+}
+
+// ExecCmdOpts executes the given command on the remote system by the given client name
+// and execution options.
+func ExecCmdOpts(ctx context.Context, cliCmd, cliName string, execOpts ExecOptions) Result {
 
 	// Get the client
 	cli, err := ByName(cliName)
 	if err != nil {
-		return err
+		return newResult(cliName).done(ErrKindConnect, err)
 	}
 
 	// Execute the command
-	cliCO, err := cli.ExecCmd(cliCmd)
+	return cli.Exec(ctx, cliCmd, execOpts)
+}
+
+// PutFiles uploads the given local file or directory to the given remote path
+// by the given client name.
+func PutFiles(ctx context.Context, src, dst, cliName string) Result {
+
+	// Get the client
+	cli, err := ByName(cliName)
 	if err != nil {
-		if cliCO == false {
-			// error by host
-			return err
-		} else {
-			// error by client
-			//return err
+		return newResult(cliName).done(ErrKindConnect, err)
+	}
+
+	return cli.Put(ctx, src, dst)
+}
+
+// GetFiles downloads the given remote file or directory into the given local directory
+// by the given client name.
+func GetFiles(ctx context.Context, src, dst, cliName string) Result {
+
+	// Get the client
+	cli, err := ByName(cliName)
+	if err != nil {
+		return newResult(cliName).done(ErrKindConnect, err)
+	}
+
+	return cli.Get(ctx, src, dst)
+}
+
+// EnvCheck checks the given environment variables (KEY=VALUE).
+func EnvCheck(env []string) error {
+
+	r := regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	for _, val := range env {
+		spl := strings.SplitN(val, "=", 2)
+		if len(spl) != 2 || r.MatchString(spl[0]) == false {
+			return errors.New("invalid environment variable (" + val + ")")
 		}
 	}
 
 	return nil
 }
 
+// expandHome expands the leading `~` of the given path by the home directory.
+func expandHome(path string) string {
+
+	if path != "~" && strings.HasPrefix(path, "~/") == false {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return home + path[1:]
+}
+
+// envMerge returns the environment variables (KEY=VALUE) of the given lists.
+// The later lists override the variables of the earlier ones.
+func envMerge(envs ...[]string) []string {
+
+	var env []string
+	keys := map[string]int{}
+	for _, list := range envs {
+		for _, val := range list {
+			key := strings.SplitN(val, "=", 2)[0]
+			if i, ok := keys[key]; ok == true {
+				env[i] = val
+				continue
+			}
+			keys[key] = len(env)
+			env = append(env, val)
+		}
+	}
+
+	return env
+}
+
+// shellQuote quotes the given string for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 // nameCheck checks the name with the given name and profile
 func nameCheck(name, profile string) error {
 
@@ -18,6 +18,7 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	dcli "github.com/cmfatih/dockerclient"
@@ -35,6 +36,7 @@ type dockerClient struct {
 	addr      string             // remote system address information
 	addrF     string             // fixed remote system address information
 	auth      ClientAuth         // remote system authentication information
+	opts      ClientOptions      // client options
 	dockerCli *dcli.DockerClient // docker client
 }
 
@@ -73,6 +75,11 @@ func (cliDocker *dockerClient) Kind() string {
 	return cliDocker.kind
 }
 
+// Addr returns the address information of the remote system.
+func (cliDocker *dockerClient) Addr() string {
+	return cliDocker.addr
+}
+
 // SetAddr sets the address information of the remote system.
 // Address can be; `unix://path` or `host:port`.
 func (cliDocker *dockerClient) SetAddr(cliAddr string) error {
@@ -106,6 +113,15 @@ func (cliDocker *dockerClient) SetAuth(cliAuth ClientAuth) error {
 	return nil
 }
 
+// SetOptions sets the options of the client.
+func (cliDocker *dockerClient) SetOptions(cliOpts ClientOptions) error {
+
+	// Check and set options
+	cliDocker.opts = cliOpts
+
+	return nil
+}
+
 // Connect establishes a connection to the remote system.
 func (cliDocker *dockerClient) Connect() error {
 
@@ -125,29 +141,44 @@ func (cliDocker *dockerClient) Connect() error {
 	return nil
 }
 
-// ExecCmd executes the given command on the remote system.
-// It uses stdout and stderr of host.
-// Be aware about return values and output! The client's stderr is different than host's stderr.
-func (cliDocker *dockerClient) ExecCmd(cliCmd string) (bool, error) {
+// Close closes the connection to the remote system.
+func (cliDocker *dockerClient) Close() error {
+	cliDocker.dockerCli = nil
+	return nil
+}
+
+// Exec executes the given command on the remote system by the given options
+// and returns the result.
+func (cliDocker *dockerClient) Exec(ctx context.Context, cliCmd string, execOpts ExecOptions) Result {
 
 	// Init vars
-	//var err error
+	res := newResult(cliDocker.name)
 
 	// Check vars
 	if cliCmd == "" {
-		return false, errors.New("missing command")
+		return res.done(ErrKindExec, errors.New("missing command"))
+	} else if ctx.Err() != nil {
+		return res.done(ctxErr(ctx))
 	}
 
 	// Connection
 	if err := cliDocker.Connect(); err != nil {
-		return false, errors.New("connection error: " + err.Error())
+		return res.done(ErrKindConnect, errors.New("connection error: "+err.Error()))
 	}
 	// MonitorEvents is not in use but just in case...
 	defer cliDocker.dockerCli.StopAllMonitorEvents()
 
 	//fmt.Println(cliDocker)
 
-	return false, errors.New("docker client implementation is still under development...")
+	return res.done(ErrKindExec, errors.New("docker client implementation is still under development..."))
+}
+
+// Put uploads the given local file or directory to the given remote path.
+func (cliDocker *dockerClient) Put(ctx context.Context, src, dst string) Result {
+	return newResult(cliDocker.name).done(ErrKindExec, errors.New("file transfer is not supported by docker clients"))
+}
 
-	return true, nil
+// Get downloads the given remote file or directory into the given local directory.
+func (cliDocker *dockerClient) Get(ctx context.Context, src, dst string) Result {
+	return newResult(cliDocker.name).done(ErrKindExec, errors.New("file transfer is not supported by docker clients"))
 }
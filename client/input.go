@@ -0,0 +1,222 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains the input (stdin) handling for the client commands.
+//
+// The piped stdin of host is read once into a shared buffer and every execution gets
+// its own reader of it, so the clients (parallel or serial) receive the same input.
+// The readers are stopped when their executions are done.
+//
+// The terminal input (stdin in raw mode) is read by a single pump and only the reader
+// of the running execution consumes it, so the keystrokes aren't sent to a finished
+// session.
+
+package client
+
+import (
+	"github.com/cmfatih/yapi/stdin"
+	"io"
+	"sync"
+)
+
+var (
+	hostInput     *inputBuffer // shared buffer of the piped stdin of host
+	hostInputOnce sync.Once    // once for the shared buffer
+	ttyInput      *inputPump   // pump of the terminal input
+	ttyInputOnce  sync.Once    // once for the terminal pump
+)
+
+// inputBuffer implements a buffer which keeps all the data of a source.
+type inputBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte // data of the source
+	err  error  // error of the source (io.EOF at the end)
+}
+
+// newInputBuffer returns a new input buffer by the given source.
+// The source is read in the background until it ends.
+func newInputBuffer(src io.Reader) *inputBuffer {
+
+	ib := new(inputBuffer)
+	ib.cond = sync.NewCond(&ib.mu)
+
+	go func() {
+		chunk := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(chunk)
+			ib.mu.Lock()
+			ib.buf = append(ib.buf, chunk[:n]...)
+			if err != nil {
+				ib.err = err
+			}
+			ib.cond.Broadcast()
+			ib.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return ib
+}
+
+// reader returns a new reader which reads the buffer from the beginning.
+func (ib *inputBuffer) reader() *inputReader {
+	return &inputReader{buf: ib}
+}
+
+// inputReader implements a reader of an input buffer.
+type inputReader struct {
+	buf     *inputBuffer
+	off     int  // read offset
+	stopped bool // whether the reader is stopped or not
+}
+
+// Read reads from the buffer. It blocks until there is data, the source ends or
+// the reader is stopped.
+func (ir *inputReader) Read(p []byte) (int, error) {
+
+	ib := ir.buf
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+
+	for ir.off >= len(ib.buf) && ib.err == nil && ir.stopped == false {
+		ib.cond.Wait()
+	}
+
+	if ir.stopped == true {
+		return 0, io.EOF
+	} else if ir.off < len(ib.buf) {
+		n := copy(p, ib.buf[ir.off:])
+		ir.off += n
+		return n, nil
+	}
+
+	return 0, ib.err
+}
+
+// stop stops the reader. The pending and the next reads return io.EOF.
+func (ir *inputReader) stop() {
+
+	ib := ir.buf
+	ib.mu.Lock()
+	defer ib.mu.Unlock()
+
+	ir.stopped = true
+	ib.cond.Broadcast()
+}
+
+// inputStopper is the interface implemented by the readers which can be stopped
+// when an execution is done.
+type inputStopper interface {
+	stop()
+}
+
+// inputStop stops the given reader if it can be stopped.
+func inputStop(r io.Reader) {
+	if is, ok := r.(inputStopper); ok == true {
+		is.stop()
+	}
+}
+
+// hostInputReader returns a new reader of the piped stdin of host.
+func hostInputReader() io.Reader {
+
+	hostInputOnce.Do(func() {
+		hostInput = newInputBuffer(stdin.StdinReader())
+	})
+
+	return hostInput.reader()
+}
+
+// inputPump implements a pump which reads a source in the background and passes
+// the data to the active reader.
+type inputPump struct {
+	mu    sync.Mutex
+	chann chan []byte // data of the source (closed at the end)
+	rest  []byte      // data which isn't consumed by a reader
+}
+
+// newInputPump returns a new input pump by the given source.
+func newInputPump(src io.Reader) *inputPump {
+
+	ip := &inputPump{chann: make(chan []byte)}
+
+	go func() {
+		for {
+			chunk := make([]byte, 4096)
+			n, err := src.Read(chunk)
+			if n > 0 {
+				ip.chann <- chunk[:n]
+			}
+			if err != nil {
+				close(ip.chann)
+				return
+			}
+		}
+	}()
+
+	return ip
+}
+
+// reader returns a new reader of the pump.
+func (ip *inputPump) reader() *pumpReader {
+	return &pumpReader{pump: ip, done: make(chan struct{})}
+}
+
+// pumpReader implements a reader of an input pump.
+type pumpReader struct {
+	pump     *inputPump
+	done     chan struct{} // closed when the reader is stopped
+	stopOnce sync.Once
+}
+
+// Read reads from the pump. It blocks until there is data, the source ends or
+// the reader is stopped.
+func (pr *pumpReader) Read(p []byte) (int, error) {
+
+	ip := pr.pump
+
+	select {
+	case <-pr.done:
+		return 0, io.EOF
+	default:
+	}
+
+	// The data which isn't consumed by the previous readers
+	ip.mu.Lock()
+	if len(ip.rest) > 0 {
+		n := copy(p, ip.rest)
+		ip.rest = ip.rest[n:]
+		ip.mu.Unlock()
+		return n, nil
+	}
+	ip.mu.Unlock()
+
+	select {
+	case data, ok := <-ip.chann:
+		if ok == false {
+			return 0, io.EOF
+		}
+		ip.mu.Lock()
+		defer ip.mu.Unlock()
+		select {
+		case <-pr.done:
+			ip.rest = append(ip.rest, data...) // for the next reader
+			return 0, io.EOF
+		default:
+		}
+		n := copy(p, data)
+		ip.rest = append(ip.rest, data[n:]...)
+		return n, nil
+	case <-pr.done:
+		return 0, io.EOF
+	}
+}
+
+// stop stops the reader. The pending and the next reads return io.EOF.
+func (pr *pumpReader) stop() {
+	pr.stopOnce.Do(func() { close(pr.done) })
+}
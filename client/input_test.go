@@ -0,0 +1,68 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// TestInputPump checks that a stopped reader doesn't consume the input of the next one.
+func TestInputPump(t *testing.T) {
+
+	src, w := io.Pipe()
+	defer w.Close()
+	ip := newInputPump(src)
+	buf := make([]byte, 16)
+
+	// First execution
+	r1 := ip.reader()
+	go w.Write([]byte("a"))
+	if n, err := r1.Read(buf); err != nil || string(buf[:n]) != "a" {
+		t.Fatalf("unexpected read: %q %v", buf[:n], err)
+	}
+
+	// A pending read returns when the reader is stopped
+	channRead := make(chan error, 1)
+	go func() {
+		_, err := r1.Read(buf)
+		channRead <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	r1.stop()
+	select {
+	case err := <-channRead:
+		if err != io.EOF {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the reader is not stopped")
+	}
+
+	// Second execution (partial reads keep the rest in the pump)
+	r2 := ip.reader()
+	go w.Write([]byte("bcd"))
+	small := make([]byte, 1)
+	var got string
+	for len(got) < 3 {
+		n, err := r2.Read(small)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += string(small[:n])
+	}
+	if got != "bcd" {
+		t.Fatalf("unexpected input: %q", got)
+	}
+	r2.stop()
+
+	// The end of the source
+	r3 := ip.reader()
+	w.Close()
+	if _, err := r3.Read(buf); err != io.EOF {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains signal forwarding for the client commands.
+//
+// References:
+//   signal request : https://tools.ietf.org/html/rfc4254#section-6.9
+//
+// A context which is canceled by a local signal (see WithSignal) carries the signal
+// to the clients and they deliver it to the remote commands.
+
+package client
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+)
+
+var (
+	signalNames = map[os.Signal]string{
+		os.Interrupt:    "INT",
+		syscall.SIGTERM: "TERM",
+		syscall.SIGHUP:  "HUP",
+	} // signal names (without SIG prefix) of the forwarded signals
+)
+
+// signalKey implements the context key of the signal holder.
+type signalKey struct{}
+
+// signalHolder implements the holder of the signal which a context is canceled by.
+type signalHolder struct {
+	mu  sync.Mutex
+	sig string
+}
+
+// WithSignal returns a copy of the given context and a function which cancels it
+// by the given local signal. The signal is delivered to the remote commands.
+// Only the first signal is kept.
+func WithSignal(ctx context.Context) (context.Context, func(sig os.Signal)) {
+
+	holder := new(signalHolder)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, signalKey{}, holder))
+
+	return ctx, func(sig os.Signal) {
+		holder.mu.Lock()
+		if holder.sig == "" {
+			holder.sig = signalNames[sig]
+			if holder.sig == "" {
+				holder.sig = "TERM" // default
+			}
+		}
+		holder.mu.Unlock()
+		cancel()
+	}
+}
+
+// CtxSignal returns the name of the signal (i.e. INT) which the given context
+// is canceled by. It returns an empty string if the context is not canceled by a signal.
+func CtxSignal(ctx context.Context) string {
+
+	holder, ok := ctx.Value(signalKey{}).(*signalHolder)
+	if ok == false {
+		return ""
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	return holder.sig
+}
+
+// ctxSignal returns the name of the signal which is delivered to the remote commands
+// when the given context is done. Default; TERM
+func ctxSignal(ctx context.Context) string {
+
+	if sig := CtxSignal(ctx); sig != "" {
+		return sig
+	}
+
+	return "TERM"
+}
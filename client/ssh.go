@@ -5,39 +5,47 @@
 // This file contains ssh client implementation.
 //
 // References:
-//   `ClientKeyring` and `ClientPassword`: http://dave.cheney.net/?p=9
-//
-// Todo:
-//   Implementation for new ssh pkg: https://groups.google.com/forum/#!msg/Golang-nuts/AoVxQ4bB5XQ/i8kpMxdbVlEJ
+//   ssh pkg : https://godoc.org/golang.org/x/crypto/ssh
+//   New ssh pkg announcement: https://groups.google.com/forum/#!msg/Golang-nuts/AoVxQ4bB5XQ/i8kpMxdbVlEJ
 
 package client
 
 import (
-	//"code.google.com/p/go.crypto/ssh"
-	"code.google.com/p/gosshold/ssh"
+	"context"
 	"errors"
-	"github.com/cmfatih/yapi/stdin"
+	"golang.org/x/crypto/ssh"
 	"io"
-	"io/ioutil"
 	"net"
 	"os"
 	"os/user"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sshSignalWait  = 1 * time.Second  // wait time for the remote command after the signal is delivered
+	sshCloseWait   = 2 * time.Second  // wait time for the remote command after the session is closed
+	sshIdleTimeout = 60 * time.Second // default idle timeout of the connections
 )
 
 // sshClient implements a ssh client
 type sshClient struct {
-	id      string           // id
-	name    string           // name
-	groups  []string         // groups
-	kind    string           // kind of client (ssh)
-	addr    string           // remote system address information
-	addrF   string           // fixed remote system address information
-	auth    ClientAuth       // remote system authentication information
-	sshConf ssh.ClientConfig // ssh client configuration
-	sshConn *ssh.ClientConn  // ssh client connection
-	sshSess *ssh.Session     // ssh session
+	id       string        // id
+	name     string        // name
+	groups   []string      // groups
+	kind     string        // kind of client (ssh)
+	addr     string        // remote system address information
+	addrF    string        // fixed remote system address information
+	auth     ClientAuth    // remote system authentication information
+	opts     ClientOptions // client options
+	mu       sync.Mutex    // mutex for the connection
+	sshConn  *ssh.Client   // ssh client connection (shared by the sessions)
+	sessCnt  int           // number of the active sessions
+	idleTmr  *time.Timer   // idle timer of the connection
+	lostConn *ssh.Client   // last lost connection
+	lostErr  error         // error of the last lost connection
 }
 
 // ID returns the unique id of the client.
@@ -75,6 +83,11 @@ func (cliSSH *sshClient) Kind() string {
 	return cliSSH.kind
 }
 
+// Addr returns the address information of the remote system.
+func (cliSSH *sshClient) Addr() string {
+	return cliSSH.addr
+}
+
 // SetAddr sets the address information of the remote system.
 // Address can be; `host` or `host:port`. Default port is `22`
 func (cliSSH *sshClient) SetAddr(cliAddr string) error {
@@ -109,174 +122,458 @@ func (cliSSH *sshClient) SetAddr(cliAddr string) error {
 func (cliSSH *sshClient) SetAuth(cliAuth ClientAuth) error {
 
 	// Check and set auth
-	ck := new(sshCK)
-
-	// Determine the username
-	// For SSH protocol username is required. So try to determine it if possible.
-	if cliAuth.Username == "" {
-
-		// WARN: It doesn't work on OSX if the cross compiler used
-
-		if u, err := user.Current(); err == nil && u != nil {
-			if u.Username != "" {
-				if runtime.GOOS == "windows" {
-					sli := strings.Split(u.Username, "\\")
-					sliLen := len(sli)
-					if sliLen > 0 {
-						cliAuth.Username = sli[sliLen-1]
-					}
-				} else {
-					cliAuth.Username = u.Username
-				}
-			}
+	// Username is determined by Connect (ssh_config or current user) if it is not set.
+
+	// Key files
+	// They are loaded by Connect since encrypted keys may require a passphrase.
+	for _, file := range sshKeyfiles(cliAuth) {
+		if _, err := os.Stat(file); err != nil {
+			return errors.New("key file couldn't be read: " + file + " - " + err.Error())
 		}
 	}
 
-	// Key file
-	if cliAuth.Keyfile != "" {
-		if err := ck.loadPEM(cliAuth.Keyfile); err != nil {
-			return errors.New("key file couldn't be read: " + cliAuth.Keyfile + " - " + err.Error())
+	cliSSH.auth = cliAuth
+
+	return nil
+}
+
+// SetOptions sets the options of the client.
+func (cliSSH *sshClient) SetOptions(cliOpts ClientOptions) error {
+
+	// Check and set options
+	if cliOpts.HostKeyPolicy == "" {
+		cliOpts.HostKeyPolicy = "strict" // default
+	} else if sshHostKeyPolicies[cliOpts.HostKeyPolicy] != true {
+		return errors.New("invalid host key policy (" + cliOpts.HostKeyPolicy + ")")
+	}
+
+	if cliOpts.HostKey != "" {
+		if _, err := sshParseHostKey(cliOpts.HostKey); err != nil {
+			return errors.New("invalid host key: " + err.Error())
+		}
+	}
+	if cliOpts.HostCAKey != "" {
+		if _, err := sshParseCAKeys(cliOpts.HostCAKey); err != nil {
+			return errors.New("invalid host CA key: " + err.Error())
 		}
 	}
 
-	if cliAuth.Username != "" || cliAuth.Password != "" || cliAuth.Keyfile != "" {
-		cliSSH.sshConf = ssh.ClientConfig{
-			User: cliAuth.Username,
-			Auth: []ssh.ClientAuth{
-				ssh.ClientAuthPassword(sshCP(cliAuth.Password)),
-				ssh.ClientAuthKeyring(ck),
-			},
+	if err := EnvCheck(cliOpts.Env); err != nil {
+		return err
+	}
+
+	for _, hop := range sshJumpHops(cliOpts.ProxyJump) {
+		if hop == "" {
+			return errors.New("invalid jump host (" + cliOpts.ProxyJump + ")")
 		}
 	}
 
-	cliSSH.auth = cliAuth
+	cliSSH.opts = cliOpts
 
 	return nil
 }
 
-// Connect establishes a connection to the remote system.
+// Connect establishes a connection to the remote system if it is not connected.
+// The connection is shared by the executions of the client and it is closed by Close
+// or when it is idle (no active session) for the idle timeout.
 func (cliSSH *sshClient) Connect() error {
 
-	// Init vars
-	var err error
+	cliSSH.mu.Lock()
+	defer cliSSH.mu.Unlock()
 
-	// Check the address
+	// Check the address and the connection
 	if cliSSH.addrF == "" {
 		return errors.New("missing address")
+	} else if cliSSH.sshConn != nil {
+		return nil
 	}
 
-	// Connect and init a session
-	if cliSSH.sshConn, err = ssh.Dial("tcp", cliSSH.addrF, &cliSSH.sshConf); err != nil {
-		return errors.New("failed to connect: " + err.Error())
+	// ssh_config
+	addr, auth, opts, err := sshConfigApply(cliSSH.addr, cliSSH.auth, cliSSH.opts)
+	if err != nil {
+		return err
 	}
 
-	if cliSSH.sshSess, err = cliSSH.sshConn.NewSession(); err != nil {
-		return errors.New("failed to create session: " + err.Error())
+	// Client configuration
+	sshConf, err := sshConfig(addr, auth, opts)
+	if err != nil {
+		return err
+	}
+
+	// Jump hosts
+	var jumpConn *ssh.Client
+	opts.ProxyJump = sshJumpTrim(opts.ProxyJump, cliSSH.name)
+	if opts.ProxyJump != "" {
+		if jumpConn, err = sshJumpConn(opts.ProxyJump, auth.Username+"@"+addr, cliSSH.opts); err != nil {
+			return err
+		}
+	}
+
+	// Connect
+	conn, err := sshDial(jumpConn, addr, sshConf, opts)
+	if err != nil {
+		return errors.New("failed to connect: " + err.Error())
 	}
+	cliSSH.sshConn = conn
+	cliSSH.idleStart()
+
+	go func() {
+		conn.Wait()
+		cliSSH.closeConn(conn)
+	}()
 
 	return nil
 }
 
-// ExecCmd executes the given command on the remote system.
-// It uses stdout and stderr of host.
-// Be aware about return values and output! The client's stderr is different than host's stderr.
-func (cliSSH *sshClient) ExecCmd(cliCmd string) (bool, error) {
+// Close closes the connection of the client if it is connected.
+func (cliSSH *sshClient) Close() error {
 
-	// Init vars
-	var err error
+	cliSSH.mu.Lock()
+	conn := cliSSH.sshConn
+	cliSSH.mu.Unlock()
 
-	// Check vars
-	if cliCmd == "" {
-		return false, errors.New("missing command")
+	if conn == nil {
+		return nil
 	}
 
-	// Connection
-	if err := cliSSH.Connect(); err != nil {
-		return false, errors.New("connection error: " + err.Error())
-	}
-	defer cliSSH.sshSess.Close()
+	return cliSSH.closeConn(conn)
+}
 
-	// client stdin
-	cliStdin, err := cliSSH.sshSess.StdinPipe()
-	if err != nil {
-		return false, errors.New("failed to execute (stdin): " + err.Error())
+// closeConn closes the given connection and removes it from the client.
+func (cliSSH *sshClient) closeConn(conn *ssh.Client) error {
+
+	if conn == nil {
+		return nil
 	}
 
-	// client stdout
-	cliStdout, err := cliSSH.sshSess.StdoutPipe()
-	if err != nil {
-		return false, errors.New("failed to execute (stdout): " + err.Error())
+	cliSSH.mu.Lock()
+	if cliSSH.sshConn == conn {
+		cliSSH.sshConn = nil
+		if cliSSH.idleTmr != nil {
+			cliSSH.idleTmr.Stop()
+		}
 	}
+	// The error of the lost connection is kept by the client for the running sessions
+	if err, ok := sshConnErrs.LoadAndDelete(conn); ok == true {
+		cliSSH.lostConn, cliSSH.lostErr = conn, err.(error)
+	}
+	cliSSH.mu.Unlock()
 
-	// client stderr
-	cliStderr, err := cliSSH.sshSess.StderrPipe()
-	if err != nil {
-		return false, errors.New("failed to execute (stderr): " + err.Error())
+	return conn.Close()
+}
+
+// connErr returns the error of the given connection if it is lost.
+func (cliSSH *sshClient) connErr(conn *ssh.Client) error {
+
+	if err := sshConnErr(conn); err != nil {
+		return err
 	}
 
-	// Start
-	if err = cliSSH.sshSess.Start(cliCmd); err != nil {
-		return false, errors.New("failed to execute: " + err.Error())
+	cliSSH.mu.Lock()
+	defer cliSSH.mu.Unlock()
+
+	if cliSSH.lostConn == conn {
+		return cliSSH.lostErr
 	}
 
-	if stdin.StdinHasPipe() == true {
-		_, err := io.Copy(cliStdin, stdin.StdinReader())
-		if err != nil {
-			return false, errors.New("failed to copy stdin: " + err.Error())
+	return nil
+}
+
+// newSession returns a new session and its connection. It connects to the remote
+// system if it is required. The session must be released by sessionDone.
+func (cliSSH *sshClient) newSession() (*ssh.Client, *ssh.Session, error) {
+
+	// Try again with a new connection if the connection is broken
+	var err error
+	for i := 0; i < 2; i++ {
+		if err = cliSSH.Connect(); err != nil {
+			return nil, nil, err
 		}
-		cliStdin.Close()
-	} else {
-		cliStdin.Close()
-	}
 
-	go io.Copy(os.Stdout, cliStdout)
-	go io.Copy(os.Stderr, cliStderr)
+		cliSSH.mu.Lock()
+		conn := cliSSH.sshConn
+		if conn == nil {
+			cliSSH.mu.Unlock()
+			continue
+		}
+		cliSSH.sessCnt++
+		if cliSSH.idleTmr != nil {
+			cliSSH.idleTmr.Stop()
+		}
+		cliSSH.mu.Unlock()
+
+		sess, errSess := conn.NewSession()
+		if errSess == nil {
+			return conn, sess, nil
+		}
 
-	return true, cliSSH.sshSess.Wait()
+		err = errors.New("failed to create session: " + errSess.Error())
+		cliSSH.sessionDone()
+		cliSSH.closeConn(conn)
+	}
+
+	return nil, nil, err
 }
 
-// sshCK implements the ClientKeyring interface.
-type sshCK struct {
-	keys []ssh.Signer
+// sessionDone releases a session and starts the idle timer if there is no active session.
+func (cliSSH *sshClient) sessionDone() {
+
+	cliSSH.mu.Lock()
+	defer cliSSH.mu.Unlock()
+
+	cliSSH.sessCnt--
+	if cliSSH.sessCnt == 0 && cliSSH.sshConn != nil {
+		cliSSH.idleStart()
+	}
 }
 
-// Key returns the public key.
-func (k *sshCK) Key(i int) (ssh.PublicKey, error) {
-	if i < 0 || i >= len(k.keys) {
-		return nil, nil
+// idleStart starts the idle timer of the connection. The caller must hold the mutex
+// and the client must be connected.
+func (cliSSH *sshClient) idleStart() {
+
+	// Init vars
+	conn := cliSSH.sshConn
+	idleTimeout := sshIdleTimeout
+	if cliSSH.opts.IdleTimeout > 0 {
+		idleTimeout = time.Duration(cliSSH.opts.IdleTimeout) * time.Millisecond
+	}
+
+	if cliSSH.idleTmr != nil {
+		cliSSH.idleTmr.Stop()
 	}
+	cliSSH.idleTmr = time.AfterFunc(idleTimeout, func() {
+		cliSSH.mu.Lock()
+		isIdle := cliSSH.sshConn == conn && cliSSH.sessCnt == 0
+		cliSSH.mu.Unlock()
 
-	return k.keys[i].PublicKey(), nil
+		if isIdle == true {
+			cliSSH.closeConn(conn)
+		}
+	})
 }
 
-// Sign returns the signature.
-func (k *sshCK) Sign(i int, rand io.Reader, data []byte) (sig []byte, err error) {
-	return k.keys[i].Sign(rand, data)
+// sshCurrentUser returns the username of the current user.
+func sshCurrentUser() string {
+
+	// WARN: It doesn't work on OSX if the cross compiler used
+
+	if u, err := user.Current(); err == nil && u != nil {
+		if u.Username != "" {
+			if runtime.GOOS == "windows" {
+				sli := strings.Split(u.Username, "\\")
+				sliLen := len(sli)
+				if sliLen > 0 {
+					return sli[sliLen-1]
+				}
+			} else {
+				return u.Username
+			}
+		}
+	}
+
+	return ""
 }
 
-// add appends the given key to the keys.
-func (k *sshCK) add(key ssh.Signer) {
-	k.keys = append(k.keys, key)
+// sshConfig returns the ssh client configuration by the given address,
+// authentication information and options.
+func sshConfig(addr string, cliAuth ClientAuth, cliOpts ClientOptions) (*ssh.ClientConfig, error) {
+
+	// Init vars
+	var err error
+	sshConf := &ssh.ClientConfig{
+		User: cliAuth.Username,
+	}
+
+	// Auth methods
+	if sshConf.Auth, err = sshAuthMethods(cliAuth); err != nil {
+		return nil, err
+	}
+
+	// Host key verification
+	if sshConf.HostKeyCallback, sshConf.HostKeyAlgorithms, err = sshHostKeyCallback(addr, cliOpts); err != nil {
+		return nil, errors.New("failed to verify host key: " + err.Error())
+	}
+
+	return sshConf, nil
 }
 
-// loadPEM loads and parses private key by the given file path.
-func (k *sshCK) loadPEM(file string) error {
-	buf, err := ioutil.ReadFile(file)
-	if err != nil {
-		return err
+// Exec executes the given command on the remote system by the given options
+// and returns the result. Each execution has its own session on the shared connection
+// so a client can execute commands concurrently.
+// If the context is done then SIGTERM (or the local signal, see WithSignal) is sent
+// to the remote command and the session is closed if it doesn't exit in time.
+// If there is a script (execOpts.Script) then it is uploaded and executed instead of
+// the command (see sshscript.go).
+// Environment variables are sent by the setenv request. If the remote system refuses it
+// (see `AcceptEnv` of sshd) then they are exported by the command instead.
+func (cliSSH *sshClient) Exec(ctx context.Context, cliCmd string, execOpts ExecOptions) Result {
+
+	// Script
+	if execOpts.Script != "" {
+		return cliSSH.execScript(ctx, execOpts)
 	}
-	key, err := ssh.ParsePrivateKey(buf)
+
+	// Init vars
+	res := newResult(cliSSH.name)
+
+	// Check vars
+	if cliCmd == "" {
+		return res.done(ErrKindExec, errors.New("missing command"))
+	} else if err := EnvCheck(execOpts.Env); err != nil {
+		return res.done(ErrKindExec, err)
+	} else if ctx.Err() != nil {
+		return res.done(ctxErr(ctx))
+	}
+
+	// Session
+	conn, sess, err := cliSSH.newSession()
 	if err != nil {
-		return err
+		return res.done(ErrKindConnect, errors.New("connection error: "+err.Error()))
+	}
+	defer cliSSH.sessionDone()
+	defer sess.Close()
+
+	// Environment variables and working directory
+	// The execution options take precedence over the client options.
+	cmdPrefix := ""
+	cmdDir := execOpts.Dir
+	if cmdDir == "" {
+		cmdDir = cliSSH.opts.Workdir
+	}
+	if cmdDir == "~" {
+		cmdPrefix += "cd ~ || exit; "
+	} else if strings.HasPrefix(cmdDir, "~/") == true {
+		cmdPrefix += "cd ~/" + shellQuote(cmdDir[2:]) + " || exit; " // relative to the home directory
+	} else if cmdDir != "" {
+		cmdPrefix += "cd " + shellQuote(cmdDir) + " || exit; "
+	}
+	becomeUser := execOpts.Become
+	if becomeUser == "" {
+		becomeUser = cliSSH.opts.Become
+	}
+	isTTY := execOpts.TTY == true || cliSSH.opts.TTY == true
+
+	for _, val := range envMerge(cliSSH.opts.Env, execOpts.Env) {
+		spl := strings.SplitN(val, "=", 2)
+		// sudo resets the environment so the variables are exported by the command
+		if becomeUser != "" || sess.Setenv(spl[0], spl[1]) != nil {
+			cmdPrefix += "export " + spl[0] + "=" + shellQuote(spl[1]) + "; "
+		}
+	}
+	cliCmd = cmdPrefix + cliCmd
+
+	// Privilege escalation
+	if becomeUser != "" {
+		cliCmd = becomeCmd(cliCmd, becomeUser, isTTY)
+	}
+
+	// Pseudo-terminal
+	if isTTY == true {
+		width, height := ttySize()
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 38400,
+			ssh.TTY_OP_OSPEED: 38400,
+		}
+		if err := sess.RequestPty(ttyTerm(), height, width, modes); err != nil {
+			return res.done(ErrKindExec, errors.New("failed to request pseudo-terminal: "+err.Error()))
+		}
+
+		// Forward the window size changes
+		stopResize := ttyResizeNotify(func(width, height int) {
+			sess.WindowChange(height, width)
+		})
+		defer stopResize()
+	}
+
+	// client stdio
+	cliStdout := newCountWriter(execOpts.Stdout)
+	cliStderr := newCountWriter(execOpts.Stderr)
+	sess.Stdout = cliStdout
+	sess.Stderr = cliStderr
+
+	// stdin is copied separately since the session waits for its EOF otherwise
+	// (i.e. a terminal never sends EOF).
+	var sessStdin io.WriteCloser
+	if execOpts.Stdin != nil || becomeUser != "" {
+		if sessStdin, err = sess.StdinPipe(); err != nil {
+			return res.done(ErrKindExec, errors.New("failed to execute: "+err.Error()))
+		}
+	}
+
+	// The become writers feed the password and forward stdin after the command is started.
+	var become *becomeState
+	var becomeOut, becomeErr *becomeWriter
+	if becomeUser != "" {
+		become = newBecome(sessStdin, execOpts.Stdin, func() (string, error) {
+			return becomePassword(cliSSH.auth)
+		})
+		becomeOut, becomeErr = become.writer(cliStdout), become.writer(cliStderr)
+		sess.Stdout, sess.Stderr = becomeOut, becomeErr
 	}
-	k.add(key)
-	return nil
-}
 
-// sshCP implements the ClientPassword interface.
-type sshCP string
+	// Start
+	if err := sess.Start(cliCmd); err != nil {
+		return res.done(ErrKindExec, errors.New("failed to execute: "+err.Error()))
+	}
+	// The stdin copy is stopped when the execution is done
+	defer inputStop(execOpts.Stdin)
+	if sessStdin != nil && become == nil {
+		go func() {
+			io.Copy(sessStdin, execOpts.Stdin)
+			sessStdin.Close()
+		}()
+	}
 
-// Password returns the password.
-func (p sshCP) Password(user string) (string, error) {
-	return string(p), nil
+	// Wait
+	channWait := make(chan error, 1)
+	go func() {
+		channWait <- sess.Wait()
+	}()
+
+	select {
+	case err := <-channWait:
+		if become != nil {
+			becomeOut.Flush()
+			becomeErr.Flush()
+		}
+		res.BytesOut = cliStdout.Count()
+		res.BytesErr = cliStderr.Count()
+		if become != nil && become.Err() != nil {
+			return res.done(ErrKindExec, become.Err())
+		}
+		if err != nil {
+			if _, ok := err.(exitStatuser); !ok {
+				if connErr := cliSSH.connErr(conn); connErr != nil {
+					return res.done(ErrKindConnect, errors.New("connection is lost: "+connErr.Error()))
+				}
+				err = errors.New("failed to execute: " + err.Error())
+			}
+		}
+
+		return res.done(ErrKindExec, err)
+
+	case <-ctx.Done():
+		// Deliver the signal (TERM or the local signal) to the remote command and
+		// wait for it briefly. Signals are not supported by some ssh servers so
+		// the session is closed if the command doesn't exit.
+		// If the remote system doesn't respond then the connection is closed.
+		sess.Signal(ssh.Signal(ctxSignal(ctx)))
+
+		select {
+		case <-channWait:
+		case <-time.After(sshSignalWait):
+			sess.Close()
+
+			select {
+			case <-channWait:
+			case <-time.After(sshCloseWait):
+				cliSSH.closeConn(conn)
+			}
+		}
+		res.BytesOut = cliStdout.Count()
+		res.BytesErr = cliStderr.Count()
+
+		return res.done(ctxErr(ctx))
+	}
 }
@@ -0,0 +1,351 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer starts a ssh server (password: pw) which executes the commands by sh
+// and forwards the direct-tcpip channels (jump host), and returns its address.
+func testServer(t *testing.T) string {
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pw []byte) (*ssh.Permissions, error) {
+			if string(pw) == "pw" {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	conf.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(nc, conf)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nch := range chans {
+					if nch.ChannelType() == "direct-tcpip" {
+						go testForward(nch)
+						continue
+					} else if nch.ChannelType() != "session" {
+						nch.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					ch, chReqs, err := nch.Accept()
+					if err != nil {
+						continue
+					}
+					go testSession(ch, chReqs)
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// testForward forwards the given direct-tcpip channel.
+func testForward(nch ssh.NewChannel) {
+
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nch.ExtraData(), &payload); err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		nch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(ch, conn)
+		ch.CloseWrite()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+}
+
+// testSession serves the requests of a session.
+func testSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+
+	var env []string
+	var mu sync.Mutex
+	var cmd *exec.Cmd
+
+	for req := range reqs {
+		switch req.Type {
+		case "env":
+			var kv struct{ Key, Val string }
+			ssh.Unmarshal(req.Payload, &kv)
+			env = append(env, kv.Key+"="+kv.Val)
+			req.Reply(true, nil)
+		case "signal":
+			mu.Lock()
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			mu.Unlock()
+		case "exec":
+			var payload struct{ Cmd string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+
+			mu.Lock()
+			cmd = exec.Command("sh", "-c", payload.Cmd)
+			cmd.Env = env
+			cmd.Stdout = ch
+			cmd.Stderr = ch.Stderr()
+			// stdin is copied separately, so the exit status doesn't wait for its EOF (like sshd)
+			cmdStdin, _ := cmd.StdinPipe()
+			err := cmd.Start()
+			mu.Unlock()
+			go func() {
+				io.Copy(cmdStdin, ch)
+				cmdStdin.Close()
+			}()
+
+			go func(cmd *exec.Cmd) {
+				code := 127
+				if err == nil {
+					code = 0
+					if err := cmd.Wait(); err != nil {
+						code = 1
+						if ee, ok := err.(*exec.ExitError); ok {
+							code = ee.ExitCode()
+						}
+					}
+				}
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, uint32(code))
+				ch.SendRequest("exit-status", false, status)
+				ch.Close()
+			}(cmd)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// testProxy starts a tcp proxy to the given address and returns its address and
+// a function which stalls the connections (the data is not forwarded anymore).
+func testProxy(t *testing.T, addr string) (string, func()) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	channStall := make(chan struct{})
+	var stallOnce sync.Once
+
+	pipe := func(dst, src net.Conn) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			select {
+			case <-channStall:
+				return // the connection is stalled (not closed)
+			default:
+			}
+			if n > 0 {
+				dst.Write(buf[:n])
+			}
+			if err != nil {
+				dst.Close()
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s, err := net.Dial("tcp", addr)
+			if err != nil {
+				c.Close()
+				continue
+			}
+			t.Cleanup(func() { c.Close(); s.Close() })
+			go pipe(s, c)
+			go pipe(c, s)
+		}
+	}()
+
+	return ln.Addr().String(), func() {
+		stallOnce.Do(func() { close(channStall) })
+	}
+}
+
+// testClient returns a new ssh client by the given name, address and options.
+// The host keys are not verified unless a host key policy is given.
+func testClient(t *testing.T, name, addr string, cliOpts ClientOptions) Client {
+
+	cli, err := New("ssh", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+
+	if cliOpts.HostKeyPolicy == "" {
+		cliOpts.HostKeyPolicy = "off"
+	}
+	cliOpts.SSHConfigFile = "none"
+	if err := cli.SetAddr(addr); err != nil {
+		t.Fatal(err)
+	} else if err := cli.SetAuth(ClientAuth{Username: "test", Password: "pw"}); err != nil {
+		t.Fatal(err)
+	} else if err := cli.SetOptions(cliOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	return cli
+}
+
+// TestExecCancelStalled cancels an execution on a stalled connection. The connection
+// is closed by Exec and the idle timer must not be started for it.
+func TestExecCancelStalled(t *testing.T) {
+
+	addr, stall := testProxy(t, testServer(t))
+	cli := testClient(t, "test_stalled", addr, ClientOptions{IdleTimeout: 50})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		stall()
+		cancel()
+	}()
+
+	res := cli.Exec(ctx, "sleep 30", ExecOptions{})
+	if res.ErrKind != ErrKindCanceled {
+		t.Fatalf("unexpected result: %s %v", res.ErrKind, res.Err)
+	}
+
+	// The idle timer would fire here
+	time.Sleep(200 * time.Millisecond)
+
+	sshCli := cli.(*sshClient)
+	sshCli.mu.Lock()
+	defer sshCli.mu.Unlock()
+	if sshCli.sshConn != nil {
+		t.Fatal("the connection is not removed")
+	}
+}
+
+// TestExecStdinShared executes commands which read the same stdin on two clients
+// in parallel and then serially with a stdin which doesn't end.
+func TestExecStdinShared(t *testing.T) {
+
+	addr := testServer(t)
+	clis := []Client{
+		testClient(t, "test_stdin1", addr, ClientOptions{}),
+		testClient(t, "test_stdin2", addr, ClientOptions{}),
+	}
+	input := strings.Repeat("line\n", 10000)
+
+	// Parallel
+	ib := newInputBuffer(strings.NewReader(input))
+	outs := make([]bytes.Buffer, len(clis))
+	var wg sync.WaitGroup
+	for i, cli := range clis {
+		wg.Add(1)
+		go func(i int, cli Client) {
+			defer wg.Done()
+			res := cli.Exec(context.Background(), "cat", ExecOptions{Stdin: ib.reader(), Stdout: &outs[i]})
+			if res.Err != nil {
+				t.Errorf("%s: %v", cli.Name(), res.Err)
+			}
+		}(i, cli)
+	}
+	wg.Wait()
+	for i := range outs {
+		if outs[i].String() != input {
+			t.Fatalf("%s: unexpected output length: %d", clis[i].Name(), outs[i].Len())
+		}
+	}
+
+	// Serial (the stdin copy of the first execution must not read anymore)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ib = newInputBuffer(pr)
+	pw.Write([]byte("first\n"))
+
+	var out1 bytes.Buffer
+	ir := ib.reader()
+	if res := clis[0].Exec(context.Background(), "head -n 1", ExecOptions{Stdin: ir, Stdout: &out1}); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if out1.String() != "first\n" {
+		t.Fatalf("unexpected output: %q", out1.String())
+	}
+	if n, err := ir.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("the reader is not stopped: %d %v", n, err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pw.Write([]byte("second\n"))
+	}()
+	var out2 bytes.Buffer
+	if res := clis[1].Exec(context.Background(), "head -n 2", ExecOptions{Stdin: ib.reader(), Stdout: &out2}); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if out2.String() != "first\nsecond\n" {
+		t.Fatalf("unexpected output: %q", out2.String())
+	}
+}
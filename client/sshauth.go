@@ -0,0 +1,331 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains authentication methods for ssh clients.
+//
+// References:
+//   agent pkg      : https://godoc.org/golang.org/x/crypto/ssh/agent
+//   openssh-key-v1 : https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+
+package client
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	sshAgentCli  agent.ExtendedAgent       // ssh-agent client (shared by the clients)
+	sshAgentErr  error                     // ssh-agent connection error
+	sshAgentMu   sync.Mutex                // mutex for ssh-agent client
+	sshKeys      = map[string]ssh.Signer{} // decrypted keys by their files
+	sshKeysMu    sync.Mutex                // mutex for the decrypted keys
+	sshPrompts   = map[string]string{}     // answers of the prompts by their questions
+	sshPromptsMu sync.Mutex                // mutex for the prompts
+)
+
+// sshAgent returns the ssh-agent client by SSH_AUTH_SOCK.
+// The connection is established once and shared by the clients.
+func sshAgent() (agent.ExtendedAgent, error) {
+
+	sshAgentMu.Lock()
+	defer sshAgentMu.Unlock()
+
+	if sshAgentCli != nil || sshAgentErr != nil {
+		return sshAgentCli, sshAgentErr
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		sshAgentErr = errors.New("ssh-agent is not available (SSH_AUTH_SOCK)")
+		return nil, sshAgentErr
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		sshAgentErr = errors.New("failed to connect ssh-agent: " + err.Error())
+		return nil, sshAgentErr
+	}
+	sshAgentCli = agent.NewClient(conn)
+
+	return sshAgentCli, nil
+}
+
+// sshSignersCallback returns a function which returns the ssh-agent signers (if the agent
+// is enabled) and the given signers. ssh-agent signers are tried first.
+func sshSignersCallback(useAgent bool, signers []ssh.Signer) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+
+		if useAgent == false {
+			return signers, nil
+		}
+
+		// ssh-agent errors are ignored if there is any other signer
+		ag, err := sshAgent()
+		if err != nil {
+			if signers != nil {
+				return signers, nil
+			}
+			return nil, err
+		}
+
+		agentSigners, err := ag.Signers()
+		if err != nil {
+			if signers != nil {
+				return signers, nil
+			}
+			return nil, errors.New("failed to get keys from ssh-agent: " + err.Error())
+		}
+
+		return append(agentSigners, signers...), nil
+	}
+}
+
+// sshAuthMethods returns the auth methods by the given auth information.
+func sshAuthMethods(cliAuth ClientAuth) ([]ssh.AuthMethod, error) {
+
+	// Init vars
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+
+	// Key files
+	// Certificates (if any) are presented before their keys.
+	for _, file := range sshKeyfiles(cliAuth) {
+		signer, err := sshKeySigner(file, cliAuth)
+		if err != nil {
+			return nil, errors.New("key file couldn't be read: " + file + " - " + err.Error())
+		}
+
+		certSigner, err := sshCertSigner(file, signer)
+		if err != nil {
+			return nil, errors.New("certificate file couldn't be read: " + file + "-cert.pub - " + err.Error())
+		} else if certSigner != nil {
+			signers = append(signers, certSigner)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	// All the public keys (agent and key files) must be in the same auth method
+	// since the auth methods are tried once by their names.
+	if signers != nil || cliAuth.Agent == true {
+		methods = append(methods, ssh.PublicKeysCallback(sshSignersCallback(cliAuth.Agent, signers)))
+	}
+	// Keyboard-interactive and password
+	// If the password is not set then it is prompted on the terminal (once for all the clients).
+	methods = append(methods, ssh.KeyboardInteractive(sshKbdInteractive(cliAuth.Password)))
+	if cliAuth.Password != "" {
+		methods = append(methods, ssh.Password(cliAuth.Password))
+	} else {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			return sshPrompt("Password: ", false)
+		}))
+	}
+
+	return methods, nil
+}
+
+// sshKbdInteractive returns the keyboard-interactive challenge function by the given password.
+// Password questions are answered by the given password if it is set, the other
+// questions (i.e. OTP) are prompted on the terminal.
+func sshKbdInteractive(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			if password != "" && echos[i] == false && sshIsPasswordPrompt(question) == true {
+				answers[i] = password
+				continue
+			}
+
+			answer, err := sshPrompt(question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+
+		return answers, nil
+	}
+}
+
+// sshPrompt prompts the given question on the terminal and returns the answer.
+// It fails if the run is not interactive (see ttyPrompt).
+// Answers are shared by the clients so a question is asked once.
+// Password questions are considered as same question.
+func sshPrompt(question string, echo bool) (string, error) {
+
+	sshPromptsMu.Lock()
+	defer sshPromptsMu.Unlock()
+
+	key := question
+	if echo == false && sshIsPasswordPrompt(question) == true {
+		key, question = "password", "Password: "
+	}
+	if answer, ok := sshPrompts[key]; ok == true {
+		return answer, nil
+	}
+
+	answer, err := ttyPrompt(question, echo)
+	if err != nil {
+		return "", err
+	}
+	sshPrompts[key] = answer
+
+	return answer, nil
+}
+
+// sshIsPasswordPrompt returns whether the given question is a password prompt or not.
+func sshIsPasswordPrompt(question string) bool {
+	return strings.Contains(strings.ToLower(question), "password")
+}
+
+// sshKeyfiles returns the key files by the given auth information.
+func sshKeyfiles(cliAuth ClientAuth) []string {
+
+	var files []string
+	if cliAuth.Keyfile != "" {
+		files = append(files, expandHome(cliAuth.Keyfile))
+	}
+	for _, file := range cliAuth.Keyfiles {
+		if file != "" {
+			files = append(files, expandHome(file))
+		}
+	}
+
+	return files
+}
+
+// sshDefaultKeyfiles returns the default key files of the current user which exist.
+// Default files; HOME/.ssh/id_ed25519, id_ecdsa and id_rsa
+func sshDefaultKeyfiles() []string {
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, kf := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		file := filepath.Join(home, ".ssh", kf)
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// sshKeySigner returns the signer by the given key file.
+// PEM (PKCS#1, PKCS#8, SEC 1) and OpenSSH (openssh-key-v1) formats are supported.
+// Encrypted keys are decrypted when they are used for the first time if their public
+// keys are available (OpenSSH format or `.pub` file), otherwise immediately.
+func sshKeySigner(file string, cliAuth ClientAuth) (ssh.Signer, error) {
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(buf)
+	var ppErr *ssh.PassphraseMissingError
+	if err == nil || errors.As(err, &ppErr) == false {
+		return signer, err
+	}
+
+	// Encrypted key
+	pubKey := ppErr.PublicKey
+	if pubKey == nil {
+		if pubBuf, err := ioutil.ReadFile(file + ".pub"); err == nil {
+			pubKey, _, _, _, _ = ssh.ParseAuthorizedKey(pubBuf)
+		}
+	}
+	if pubKey == nil {
+		return sshKeyDecrypt(file, buf, cliAuth)
+	}
+
+	return &sshKeyLazy{file: file, buf: buf, pubKey: pubKey, auth: cliAuth}, nil
+}
+
+// sshKeyDecrypt decrypts the given encrypted key and returns the signer.
+// The passphrase is; Passphrase, the value of PassphraseEnv or prompted on the terminal.
+// Decrypted keys are shared by the clients so the passphrase is asked once per key file.
+func sshKeyDecrypt(file string, buf []byte, cliAuth ClientAuth) (ssh.Signer, error) {
+
+	sshKeysMu.Lock()
+	defer sshKeysMu.Unlock()
+
+	if signer, ok := sshKeys[file]; ok == true {
+		return signer, nil
+	}
+
+	// Determine the passphrase
+	pass := cliAuth.Passphrase
+	if pass == "" && cliAuth.PassphraseEnv != "" {
+		pass = os.Getenv(cliAuth.PassphraseEnv)
+	}
+	if pass == "" {
+		var err error
+		if pass, err = ttyPassword("Enter passphrase for key '" + file + "': "); err != nil {
+			return nil, errors.New("missing passphrase: " + err.Error())
+		}
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(buf, []byte(pass))
+	if err != nil {
+		return nil, errors.New("failed to decrypt the key: " + err.Error())
+	}
+	sshKeys[file] = signer
+
+	return signer, nil
+}
+
+// sshKeyLazy implements a signer for an encrypted key which is decrypted on
+// the first signature. So the passphrase is not asked unless the remote system
+// accepts the public key.
+type sshKeyLazy struct {
+	file   string        // key file
+	buf    []byte        // encrypted key
+	pubKey ssh.PublicKey // public key
+	auth   ClientAuth    // auth information
+}
+
+// PublicKey returns the public key.
+func (kl *sshKeyLazy) PublicKey() ssh.PublicKey {
+	return kl.pubKey
+}
+
+// Algorithms returns the signature algorithms of the key.
+func (kl *sshKeyLazy) Algorithms() []string {
+	return sshKeyAlgos([]string{kl.pubKey.Type()})
+}
+
+// Sign decrypts the key (if it is not) and returns the signature of the given data.
+func (kl *sshKeyLazy) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return kl.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm decrypts the key (if it is not) and returns the signature of
+// the given data by the given algorithm.
+func (kl *sshKeyLazy) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+
+	signer, err := sshKeyDecrypt(kl.file, kl.buf, kl.auth)
+	if err != nil {
+		return nil, errors.New("key file couldn't be read: " + kl.file + " - " + err.Error())
+	}
+
+	if as, ok := signer.(ssh.AlgorithmSigner); ok == true && algorithm != "" {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+
+	return signer.Sign(rand, data)
+}
@@ -0,0 +1,203 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains OpenSSH certificate support for ssh clients.
+//
+// References:
+//   certificates : https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.certkeys
+//
+// User certificates are loaded from `KEYFILE-cert.pub` files (and from ssh-agent)
+// and presented before the keys. Host certificates are verified by the host CA keys
+// (hostCAKey option), the other host keys (and the keys of the host certificates which
+// are signed by an unknown CA) are verified as usual. The certificates and the CA keys
+// which are marked as `@revoked` in the known_hosts files are refused.
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+var (
+	sshCertAlgos = []string{
+		ssh.CertAlgoED25519v01,
+		ssh.CertAlgoECDSA256v01,
+		ssh.CertAlgoECDSA384v01,
+		ssh.CertAlgoECDSA521v01,
+		ssh.CertAlgoRSASHA512v01,
+		ssh.CertAlgoRSASHA256v01,
+	} // host certificate algorithms
+)
+
+// sshCertSigner returns the certificate signer of the given key file and signer if
+// there is a certificate file (`KEYFILE-cert.pub`). Otherwise returns nil.
+func sshCertSigner(file string, signer ssh.Signer) (ssh.Signer, error) {
+
+	buf, err := ioutil.ReadFile(file + "-cert.pub")
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+	if err != nil {
+		return nil, errors.New("invalid certificate file: " + err.Error())
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if ok == false {
+		return nil, errors.New("invalid certificate file: " + file + "-cert.pub is not a certificate")
+	} else if cert.CertType != ssh.UserCert {
+		return nil, errors.New("invalid certificate file: " + file + "-cert.pub is not a user certificate")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, errors.New("invalid certificate file: " + err.Error())
+	}
+
+	return certSigner, nil
+}
+
+// sshParseCAKeys parses the given host CA keys.
+// It can be a public key (i.e. `ssh-ed25519 AAAA...`) or a file which contains the public keys
+// (authorized_keys format, one key per line).
+func sshParseCAKeys(hostCAKey string) ([]ssh.PublicKey, error) {
+
+	// Init vars
+	var keys []ssh.PublicKey
+	buf := []byte(hostCAKey)
+
+	if key, _, _, _, err := ssh.ParseAuthorizedKey(buf); err == nil {
+		return []ssh.PublicKey{key}, nil
+	}
+
+	// File
+	buf, err := ioutil.ReadFile(expandHome(hostCAKey))
+	if err != nil {
+		return nil, err
+	}
+
+	for len(bytes.TrimSpace(buf)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(buf)
+		if err != nil {
+			return nil, errors.New("invalid public key in " + hostCAKey + ": " + err.Error())
+		}
+		keys = append(keys, key)
+		buf = rest
+	}
+
+	if keys == nil {
+		return nil, errors.New("there is no public key in " + hostCAKey)
+	}
+
+	return keys, nil
+}
+
+// sshHostCertCallback returns the host key callback and the host key algorithms
+// which verify the host certificates by the host CA keys of the given options.
+// The other host keys are verified by the given host key callback.
+func sshHostCertCallback(hkcb ssh.HostKeyCallback, algos []string, cliOpts ClientOptions) (ssh.HostKeyCallback, []string, error) {
+
+	caKeys, err := sshParseCAKeys(cliOpts.HostCAKey)
+	if err != nil {
+		return nil, nil, errors.New("invalid host CA key: " + err.Error())
+	}
+
+	_, files, err := sshKnownHostsFiles(cliOpts.KnownHostsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	revokedKeys, err := sshRevokedKeys(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return sshKeyIn(auth, caKeys)
+		},
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return sshKeyIn(cert, revokedKeys) || sshKeyIn(cert.Key, revokedKeys) || sshKeyIn(cert.SignatureKey, revokedKeys)
+		},
+		HostKeyFallback: hkcb,
+	}
+
+	// Prefer the host certificates
+	if algos == nil {
+		algos = ssh.SupportedAlgorithms().HostKeys
+	}
+	certAlgos := append([]string{}, sshCertAlgos...)
+	for _, algo := range algos {
+		if strings.Contains(algo, "-cert-") == false {
+			certAlgos = append(certAlgos, algo)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+
+		// The key of a host certificate which is signed by an unknown CA is verified as usual
+		if cert, ok := key.(*ssh.Certificate); ok == true && cert.CertType == ssh.HostCert && sshKeyIn(cert.SignatureKey, caKeys) == false {
+			if sshKeyIn(cert.SignatureKey, revokedKeys) == true {
+				return errors.New("invalid host certificate: revoked CA key (" + ssh.FingerprintSHA256(cert.SignatureKey) + ")")
+			}
+			return hkcb(hostname, remote, cert.Key)
+		}
+
+		if err := checker.CheckHostKey(hostname, remote, key); err != nil {
+			if _, ok := key.(*ssh.Certificate); ok == true {
+				return errors.New("invalid host certificate: " + err.Error())
+			}
+			return err
+		}
+		return nil
+	}, certAlgos, nil
+}
+
+// sshRevokedKeys returns the revoked keys (`@revoked` lines) of the given known_hosts files.
+func sshRevokedKeys(files []string) ([]ssh.PublicKey, error) {
+
+	var keys []ssh.PublicKey
+
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(buf), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "@revoked") == false {
+				continue
+			}
+			_, _, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+			if err != nil {
+				return nil, errors.New("invalid known_hosts line in " + file + ": " + err.Error())
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// sshKeyIn returns whether the given key is in the given keys or not.
+func sshKeyIn(key ssh.PublicKey, keys []ssh.PublicKey) bool {
+
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) == true {
+			return true
+		}
+	}
+
+	return false
+}
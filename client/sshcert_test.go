@@ -0,0 +1,99 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testCert returns a host certificate of the given key which is signed by the given CA.
+func testCert(t *testing.T, key ssh.PublicKey, ca ssh.Signer) *ssh.Certificate {
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// testSigner returns a new ed25519 signer.
+func testSigner(t *testing.T) ssh.Signer {
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// TestSSHHostCertCallback checks the host certificates of the known and unknown CAs
+// and the revoked ones.
+func TestSSHHostCertCallback(t *testing.T) {
+
+	ca, otherCA, revokedCA := testSigner(t), testSigner(t), testSigner(t)
+	hostKey, revokedKey := testSigner(t).PublicKey(), testSigner(t).PublicKey()
+	addr := "127.0.0.1:22"
+
+	caKeys := string(ssh.MarshalAuthorizedKey(ca.PublicKey())) + string(ssh.MarshalAuthorizedKey(revokedCA.PublicKey()))
+	knownHosts := knownhosts.Line([]string{addr}, hostKey) + "\n" +
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedKey))) + "\n" +
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedCA.PublicKey()))) + "\n"
+
+	dir := t.TempDir()
+	caFile, khFile := filepath.Join(dir, "ca.pub"), filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(caFile, []byte(caKeys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(khFile, []byte(knownHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cliOpts := ClientOptions{HostKeyPolicy: "strict", HostCAKey: caFile, KnownHostsFile: khFile}
+	hkcb, _, err := sshHostKeyCallback(addr, cliOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unknownKey := testSigner(t).PublicKey()
+	tests := []struct {
+		name    string
+		key     ssh.PublicKey
+		wantErr string
+	}{
+		{"known CA", testCert(t, unknownKey, ca), ""},
+		{"unknown CA known key", testCert(t, hostKey, otherCA), ""},
+		{"unknown CA changed key", testCert(t, unknownKey, otherCA), "host key mismatch"},
+		{"revoked key", testCert(t, revokedKey, ca), "revoked"},
+		{"revoked CA", testCert(t, unknownKey, revokedCA), "revoked"},
+		{"known plain key", hostKey, ""},
+	}
+
+	for _, test := range tests {
+		err := hkcb(addr, sshRemoteAddr(addr), test.key)
+		if test.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		} else if test.wantErr != "" && (err == nil || strings.Contains(err.Error(), test.wantErr) == false) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains ssh_config support for ssh clients.
+//
+// References:
+//   ssh_config : http://man.openbsd.org/ssh_config
+//
+// Supported keywords; Host, Match (all, host, originalhost, user, localuser, canonical,
+// final), Include, HostName, User, Port, IdentityFile and ProxyJump. Others are ignored.
+// The other Match criteria (i.e. exec) don't match (negated or not) and they are warned once.
+// As ssh does, the first obtained value is used for a keyword (IdentityFile values
+// are accumulated) and the settings of the client take precedence.
+// Included files have their own Host and Match scopes.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	sshConfigSys   = "/etc/ssh/ssh_config"        // system-wide ssh_config file
+	sshConfigFiles = map[string][]sshConfigLine{} // parsed ssh_config files by their paths
+	sshConfigMu    sync.Mutex                     // mutex for the parsed ssh_config files
+
+	sshConfigWarns            = map[string]bool{} // warned unsupported Match criteria
+	sshConfigWarnW io.Writer  = os.Stderr         // writer for the warnings
+	sshConfigWarnM sync.Mutex                     // mutex for the warnings
+)
+
+// sshConfigLine implements a ssh_config line.
+type sshConfigLine struct {
+	key      string            // keyword (lowercase)
+	args     []string          // arguments
+	includes [][]sshConfigLine // lines of the included files (Include)
+}
+
+// sshConfigHost implements the ssh_config settings of a host.
+type sshConfigHost struct {
+	HostName      string
+	User          string
+	Port          string
+	IdentityFiles []string
+	ProxyJump     string
+}
+
+// sshConfigApply applies the ssh_config settings of the host of the given address to
+// the given authentication information and options, and returns the fixed address.
+// The settings of the client take precedence. `none` ssh_config file disables it.
+func sshConfigApply(addr string, cliAuth ClientAuth, cliOpts ClientOptions) (string, ClientAuth, ClientOptions, error) {
+
+	// Init vars
+	host, port := addr, ""
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		host, port = addr[:i], addr[i+1:]
+	}
+
+	// ssh_config
+	var hostConf sshConfigHost
+	if cliOpts.SSHConfigFile != "none" {
+		var err error
+		if hostConf, err = sshConfigLookup(cliOpts.SSHConfigFile, host, cliAuth.Username); err != nil {
+			return "", cliAuth, cliOpts, err
+		}
+	}
+
+	// Address
+	if hostConf.HostName != "" {
+		host = hostConf.HostName
+	}
+	if port == "" {
+		port = hostConf.Port
+	}
+	if port == "" {
+		port = "22" // default
+	}
+
+	// Auth
+	if cliAuth.Username == "" {
+		cliAuth.Username = hostConf.User
+	}
+	if cliAuth.Username == "" {
+		cliAuth.Username = sshCurrentUser()
+	}
+	// Key files (the default ones after IdentityFile)
+	keyfiles := hostConf.IdentityFiles
+	if cliAuth.DefaultKeyfiles == true {
+		keyfiles = append(keyfiles, sshDefaultKeyfiles()...)
+	}
+	known := make(map[string]bool)
+	for _, file := range sshKeyfiles(cliAuth) {
+		known[file] = true
+	}
+	for _, file := range keyfiles {
+		if _, err := os.Stat(file); err == nil && known[file] == false {
+			cliAuth.Keyfiles = append(cliAuth.Keyfiles, file)
+			known[file] = true
+		}
+	}
+
+	// Options
+	if cliOpts.ProxyJump == "" {
+		cliOpts.ProxyJump = hostConf.ProxyJump
+	}
+	if cliOpts.ProxyJump == "none" {
+		cliOpts.ProxyJump = ""
+	}
+
+	return host + ":" + port, cliAuth, cliOpts, nil
+}
+
+// sshConfigLookup returns the ssh_config settings by the given ssh_config file, host (alias)
+// and username. Default files; HOME/.ssh/config and /etc/ssh/ssh_config
+func sshConfigLookup(file, host, username string) (sshConfigHost, error) {
+
+	// Init vars
+	var hostConf sshConfigHost
+	var files []string
+
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			files = append(files, filepath.Join(home, ".ssh", "config"))
+		}
+		files = append(files, sshConfigSys)
+	} else {
+		files = append(files, expandHome(file))
+	}
+
+	for i, f := range files {
+		lines, err := sshConfigParse(f, i == 0)
+		if err != nil {
+			if os.IsNotExist(err) == true && file == "" {
+				continue // default files are optional
+			}
+			return hostConf, errors.New("failed to read ssh_config file: " + err.Error())
+		}
+
+		if err := sshConfigEval(lines, &hostConf, host, username); err != nil {
+			return hostConf, errors.New("invalid ssh_config file (" + f + "): " + err.Error())
+		}
+	}
+
+	return hostConf, nil
+}
+
+// sshConfigEval evaluates the given ssh_config lines for the given host and username.
+// The lines of an included file are evaluated in their own scope, so their Host and
+// Match lines don't affect the lines after the Include.
+func sshConfigEval(lines []sshConfigLine, hostConf *sshConfigHost, host, username string) error {
+
+	isActive := true
+	for _, line := range lines {
+		switch line.key {
+		case "host":
+			isActive = sshConfigMatchList(host, line.args)
+		case "match":
+			isMatch, err := sshConfigMatch(line.args, host, *hostConf, username)
+			if err != nil {
+				return err
+			}
+			isActive = isMatch
+		case "include":
+			if isActive == false {
+				continue
+			}
+			for _, incLines := range line.includes {
+				if err := sshConfigEval(incLines, hostConf, host, username); err != nil {
+					return err
+				}
+			}
+		default:
+			if isActive == true {
+				sshConfigSet(hostConf, line, host, username)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sshConfigSet sets the given ssh_config setting if it is not set yet.
+func sshConfigSet(hostConf *sshConfigHost, line sshConfigLine, host, username string) {
+
+	if len(line.args) == 0 {
+		return
+	}
+	val := line.args[0]
+
+	switch line.key {
+	case "hostname":
+		if hostConf.HostName == "" {
+			hostConf.HostName = sshConfigTokens(val, host, "", "")
+		}
+	case "user":
+		if hostConf.User == "" {
+			hostConf.User = val
+		}
+	case "port":
+		if _, err := strconv.Atoi(val); err == nil && hostConf.Port == "" {
+			hostConf.Port = val
+		}
+	case "identityfile":
+		if username == "" {
+			username = hostConf.User
+		}
+		hostName := hostConf.HostName
+		if hostName == "" {
+			hostName = host
+		}
+		hostConf.IdentityFiles = append(hostConf.IdentityFiles, expandHome(sshConfigTokens(val, host, hostName, username)))
+	case "proxyjump":
+		if hostConf.ProxyJump == "" {
+			hostConf.ProxyJump = val
+		}
+	}
+}
+
+// sshConfigMatch returns whether the given Match criteria matches the given host or not.
+// The unsupported criteria don't match and they are warned once.
+// It returns an error for the missing criteria arguments.
+func sshConfigMatch(args []string, host string, hostConf sshConfigHost, username string) (bool, error) {
+
+	// Init vars
+	hostName := hostConf.HostName
+	if hostName == "" {
+		hostName = host
+	}
+	if username == "" {
+		username = hostConf.User
+	}
+	if username == "" {
+		username = sshCurrentUser()
+	}
+
+	// All the criteria are checked (not only until the first mismatch)
+	result := true
+	for i := 0; i < len(args); i++ {
+		crit := strings.ToLower(args[i])
+		isNeg := strings.HasPrefix(crit, "!")
+		crit = strings.TrimPrefix(crit, "!")
+
+		isMatch := false
+		switch crit {
+		case "all":
+			isMatch = true
+		case "final":
+			isMatch = true // there is only one pass
+		case "canonical":
+			isMatch = false // host names are not canonicalized
+		case "host", "originalhost", "user", "localuser":
+			if i+1 >= len(args) {
+				return false, errors.New("missing Match " + crit + " argument")
+			}
+			i++
+			patterns := strings.Split(args[i], ",")
+			switch crit {
+			case "host":
+				isMatch = sshConfigMatchList(hostName, patterns)
+			case "originalhost":
+				isMatch = sshConfigMatchList(host, patterns)
+			case "user":
+				isMatch = sshConfigMatchList(username, patterns)
+			case "localuser":
+				isMatch = sshConfigMatchList(sshCurrentUser(), patterns)
+			}
+		default:
+			// The argument of the criteria (i.e. exec command) is skipped too
+			sshConfigWarn(crit)
+			if i+1 < len(args) {
+				i++
+			}
+			result = false
+			continue
+		}
+
+		if isMatch == isNeg {
+			result = false
+		}
+	}
+
+	return result, nil
+}
+
+// sshConfigWarn warns the given unsupported Match criteria once.
+func sshConfigWarn(crit string) {
+
+	sshConfigWarnM.Lock()
+	defer sshConfigWarnM.Unlock()
+
+	if sshConfigWarns[crit] == false {
+		sshConfigWarns[crit] = true
+		fmt.Fprintf(sshConfigWarnW, "Warning: unsupported ssh_config Match criteria (%s) doesn't match\n", crit)
+	}
+}
+
+// sshConfigMatchList returns whether the given value matches the given patterns or not.
+// A negated pattern (`!pattern`) match returns false.
+func sshConfigMatchList(val string, patterns []string) bool {
+
+	isMatch := false
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") == true {
+			if sshConfigMatchPattern(val, pattern[1:]) == true {
+				return false
+			}
+		} else if sshConfigMatchPattern(val, pattern) == true {
+			isMatch = true
+		}
+	}
+
+	return isMatch
+}
+
+// sshConfigMatchPattern returns whether the given value matches the given pattern
+// (`*` and `?` wildcards) or not.
+func sshConfigMatchPattern(val, pattern string) bool {
+
+	if pattern == "" {
+		return val == ""
+	}
+
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(val); i++ {
+			if sshConfigMatchPattern(val[i:], pattern[1:]) == true {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return val != "" && sshConfigMatchPattern(val[1:], pattern[1:])
+	}
+
+	return val != "" && strings.EqualFold(val[:1], pattern[:1]) && sshConfigMatchPattern(val[1:], pattern[1:])
+}
+
+// sshConfigTokens expands the tokens (%h, %n, %r, %u, %d and %%) of the given value.
+func sshConfigTokens(val, host, hostName, username string) string {
+
+	if strings.Contains(val, "%") == false {
+		return val
+	}
+
+	if hostName == "" {
+		hostName = host
+	}
+	home, _ := os.UserHomeDir()
+
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", hostName,
+		"%n", host,
+		"%r", username,
+		"%u", sshCurrentUser(),
+		"%d", home,
+	).Replace(val)
+}
+
+// sshConfigParse parses the given ssh_config file and returns the lines.
+// The lines of the included files are kept by their Include lines. Relative include
+// paths are relative to HOME/.ssh for the user file, /etc/ssh otherwise.
+// Parsed files are cached.
+func sshConfigParse(file string, isUser bool) ([]sshConfigLine, error) {
+
+	sshConfigMu.Lock()
+	defer sshConfigMu.Unlock()
+
+	return sshConfigParseFile(file, isUser, 0)
+}
+
+// sshConfigParseFile parses the given ssh_config file by the given include depth.
+func sshConfigParseFile(file string, isUser bool, depth int) ([]sshConfigLine, error) {
+
+	if lines, ok := sshConfigFiles[file]; ok == true {
+		return lines, nil
+	} else if depth > 16 {
+		return nil, errors.New("too many include levels: " + file)
+	}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []sshConfigLine
+	for _, text := range strings.Split(string(buf), "\n") {
+
+		// Parse the line (`Keyword value` or `Keyword=value`)
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") == true {
+			continue
+		}
+
+		key, rest := text, ""
+		if i := strings.IndexAny(text, " \t="); i >= 0 {
+			key, rest = text[:i], strings.TrimSpace(text[i:])
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+		}
+		line := sshConfigLine{key: strings.ToLower(key), args: sshConfigArgs(rest)}
+
+		if line.key != "include" {
+			lines = append(lines, line)
+			continue
+		}
+
+		// Include (every file has its own scope)
+		for _, pattern := range line.args {
+			pattern = expandHome(pattern)
+			if filepath.IsAbs(pattern) == false {
+				if isUser == true {
+					home, _ := os.UserHomeDir()
+					pattern = filepath.Join(home, ".ssh", pattern)
+				} else {
+					pattern = filepath.Join(filepath.Dir(sshConfigSys), pattern)
+				}
+			}
+
+			matches, _ := filepath.Glob(pattern)
+			for _, match := range matches {
+				incLines, err := sshConfigParseFile(match, isUser, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				line.includes = append(line.includes, incLines)
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	sshConfigFiles[file] = lines
+
+	return lines, nil
+}
+
+// sshConfigArgs splits the given arguments by whitespaces (quoted arguments are supported).
+func sshConfigArgs(s string) []string {
+
+	var args []string
+	var arg []rune
+	inQuote, hasArg := false, false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && inQuote == false:
+			if hasArg == true {
+				args = append(args, string(arg))
+				arg, hasArg = nil, false
+			}
+		default:
+			arg = append(arg, r)
+			hasArg = true
+		}
+	}
+	if hasArg == true {
+		args = append(args, string(arg))
+	}
+
+	return args
+}
@@ -0,0 +1,201 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSSHConfigLookup checks the ssh_config settings of the hosts.
+// DIR in the files is replaced by the directory of the files.
+func TestSSHConfigLookup(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		files    map[string]string // file name -> content (config is the main file)
+		host     string
+		username string
+		want     sshConfigHost
+		wantErr  string
+	}{
+		{
+			name:  "alias",
+			files: map[string]string{"config": "Host web\n  HostName web.example.com\n  User deploy\n  Port 2222\n"},
+			host:  "web",
+			want:  sshConfigHost{HostName: "web.example.com", User: "deploy", Port: "2222"},
+		},
+		{
+			name:  "first value",
+			files: map[string]string{"config": "Host web*\n  User first\nHost *\n  User second\n  Port=2200\n"},
+			host:  "web1",
+			want:  sshConfigHost{User: "first", Port: "2200"},
+		},
+		{
+			name:  "negated pattern",
+			files: map[string]string{"config": "Host * !db*\n  User app\n"},
+			host:  "db1",
+			want:  sshConfigHost{},
+		},
+		{
+			name: "include scope",
+			files: map[string]string{
+				"config":   "Host web\n  Include DIR/inc.conf\n  Port 2222\n",
+				"inc.conf": "User inc\nHost other\n  HostName other.example.com\n",
+			},
+			host: "web",
+			want: sshConfigHost{User: "inc", Port: "2222"},
+		},
+		{
+			name: "inactive include",
+			files: map[string]string{
+				"config":   "Host db\n  Include DIR/inc.conf\nHost *\n  User all\n",
+				"inc.conf": "User inc\n",
+			},
+			host: "web",
+			want: sshConfigHost{User: "all"},
+		},
+		{
+			name: "include files",
+			files: map[string]string{
+				"config":    "Include DIR/inc*.conf\nUser outer\n",
+				"inc1.conf": "Host nomatch\n",
+				"inc2.conf": "Port 2022\n",
+			},
+			host: "web",
+			want: sshConfigHost{User: "outer", Port: "2022"},
+		},
+		{
+			name:     "match",
+			files:    map[string]string{"config": "Match host web* user deploy\n  Port 2222\nMatch !host web*\n  User other\nMatch all\n  User all\n"},
+			host:     "web1",
+			username: "deploy",
+			want:     sshConfigHost{User: "all", Port: "2222"},
+		},
+		{
+			name:  "match hostname",
+			files: map[string]string{"config": "Host web\n  HostName web.example.com\nMatch host *.example.com originalhost web\n  User deploy\n"},
+			host:  "web",
+			want:  sshConfigHost{HostName: "web.example.com", User: "deploy"},
+		},
+		{
+			name:  "match canonical",
+			files: map[string]string{"config": "Match canonical host web\n  User canonical\nMatch final host web\n  User final\n"},
+			host:  "web",
+			want:  sshConfigHost{User: "final"},
+		},
+		{
+			name:  "match exec",
+			files: map[string]string{"config": "Match host web exec \"test -f /tmp/x\"\n  User exec\nMatch !exec x\n  Port 2222\nHost *\n  User other\n"},
+			host:  "web",
+			want:  sshConfigHost{User: "other"},
+		},
+		{
+			name:    "match missing argument",
+			files:   map[string]string{"config": "Match user\n  User x\n"},
+			host:    "web",
+			wantErr: "missing Match user argument",
+		},
+		{
+			name:  "include unsupported match",
+			files: map[string]string{"config": "Include DIR/inc.conf\nHost web\n  Port 2222\n", "inc.conf": "Match localnetwork 10.0.0.0/8\n  User local\n"},
+			host:  "web",
+			want:  sshConfigHost{Port: "2222"},
+		},
+		{
+			name:     "identity file tokens",
+			files:    map[string]string{"config": "Host web\n  HostName web.example.com\n  IdentityFile /keys/%h_%r\n  IdentityFile /keys/%n\n"},
+			host:     "web",
+			username: "deploy",
+			want:     sshConfigHost{HostName: "web.example.com", IdentityFiles: []string{"/keys/web.example.com_deploy", "/keys/web"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dir := t.TempDir()
+			for name, content := range test.files {
+				content = strings.ReplaceAll(content, "DIR", dir)
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := sshConfigLookup(filepath.Join(dir, "config"), test.host, test.username)
+			if test.wantErr != "" {
+				if err == nil || strings.HasSuffix(err.Error(), test.wantErr) == false {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.DeepEqual(got, test.want) == false {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestSSHConfigWarn checks that an unsupported Match criteria is warned once.
+func TestSSHConfigWarn(t *testing.T) {
+
+	var buf bytes.Buffer
+	sshConfigWarnM.Lock()
+	sshConfigWarns, sshConfigWarnW = map[string]bool{}, &buf
+	sshConfigWarnM.Unlock()
+	defer func() {
+		sshConfigWarnM.Lock()
+		sshConfigWarnW = os.Stderr
+		sshConfigWarnM.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		isMatch, err := sshConfigMatch([]string{"tagged", "prod"}, "web", sshConfigHost{}, "u")
+		if err != nil || isMatch == true {
+			t.Fatalf("got %v, %v, want false, nil", isMatch, err)
+		}
+	}
+	if got := strings.Count(buf.String(), "(tagged)"); got != 1 {
+		t.Fatalf("got %d warnings (%q), want 1", got, buf.String())
+	}
+}
+
+// TestSSHConfigApplyKeyfiles checks that the default key files are added after
+// the IdentityFile files.
+func TestSSHConfigApplyKeyfiles(t *testing.T) {
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	for _, name := range []string{".ssh/id_ed25519", ".ssh/id_rsa", ".ssh/web_key"} {
+		if err := os.WriteFile(filepath.Join(home, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := filepath.Join(home, ".ssh", "config")
+	if err := os.WriteFile(conf, []byte("Host web\n  IdentityFile ~/.ssh/web_key\n  IdentityFile ~/.ssh/id_rsa\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, auth, _, err := sshConfigApply("web", ClientAuth{Username: "u", DefaultKeyfiles: true}, ClientOptions{SSHConfigFile: conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(home, ".ssh", "web_key"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+		filepath.Join(home, ".ssh", "id_ed25519"),
+	}
+	if reflect.DeepEqual(auth.Keyfiles, want) == false {
+		t.Fatalf("got %v, want %v", auth.Keyfiles, want)
+	}
+}
@@ -0,0 +1,172 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains connection related functions (timeouts and keepalives) for ssh clients.
+//
+// References:
+//   keepalive : http://man.openbsd.org/ssh_config#ServerAliveInterval
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	sshConnectTimeout    = 10 * time.Second // default connect timeout
+	sshHandshakeTimeout  = 60 * time.Second // default handshake timeout
+	sshKeepaliveCountMax = 3                // default max number of the unanswered keepalives
+)
+
+var (
+	sshConnErrs sync.Map // errors of the lost connections (i.e. keepalive timeout) until they are closed
+)
+
+// sshDial connects to the given address through the given jump host connection
+// (if any) by the given options and returns the client connection.
+func sshDial(jumpConn *ssh.Client, addr string, sshConf *ssh.ClientConfig, cliOpts ClientOptions) (*ssh.Client, error) {
+
+	// Init vars
+	connectTimeout := sshTimeout(cliOpts.ConnectTimeout, sshConnectTimeout)
+	handshakeTimeout := sshTimeout(cliOpts.HandshakeTimeout, sshHandshakeTimeout)
+
+	// Connect
+	ctx := context.Background()
+	if connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+	}
+
+	var conn net.Conn
+	var err error
+	if jumpConn == nil {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = jumpConn.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("connect timeout (%dms)", connectTimeout.Milliseconds())
+		}
+		return nil, err
+	}
+
+	// Handshake
+	// The connection is closed if the handshake is not completed in time.
+	var isTimeout bool
+	var timeoutMu sync.Mutex
+	var timer *time.Timer
+	if handshakeTimeout > 0 {
+		timer = time.AfterFunc(handshakeTimeout, func() {
+			timeoutMu.Lock()
+			isTimeout = true
+			timeoutMu.Unlock()
+			conn.Close()
+		})
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConf)
+	if timer != nil {
+		timer.Stop()
+	}
+	if err != nil {
+		conn.Close()
+		timeoutMu.Lock()
+		defer timeoutMu.Unlock()
+		if isTimeout == true {
+			return nil, fmt.Errorf("handshake timeout (%dms)", handshakeTimeout.Milliseconds())
+		}
+		return nil, err
+	}
+
+	cliConn := ssh.NewClient(c, chans, reqs)
+
+	// Keepalive
+	if cliOpts.KeepaliveInterval > 0 {
+		go sshKeepalive(cliConn, cliOpts)
+	}
+
+	return cliConn, nil
+}
+
+// sshKeepalive sends keepalive requests to the given connection by the given options.
+// The connection is closed if the number of the unanswered keepalives reaches
+// KeepaliveCountMax. It returns when the connection is closed.
+func sshKeepalive(conn *ssh.Client, cliOpts ClientOptions) {
+
+	// Init vars
+	interval := time.Duration(cliOpts.KeepaliveInterval) * time.Millisecond
+	countMax := cliOpts.KeepaliveCountMax
+	if countMax <= 0 {
+		countMax = sshKeepaliveCountMax
+	}
+
+	channClosed := make(chan struct{})
+	go func() {
+		conn.Wait()
+		close(channClosed)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Any reply (including failure) means the remote system is alive
+	channReply := make(chan struct{}, 1)
+	missed, isPending := 0, false
+
+	for {
+		select {
+		case <-channClosed:
+			return
+		case <-channReply:
+			missed, isPending = 0, false
+		case <-ticker.C:
+			if isPending == true {
+				missed++
+				if missed >= countMax {
+					sshConnErrs.Store(conn, fmt.Errorf("keepalive timeout (%d keepalives are unanswered, interval: %dms)", missed, cliOpts.KeepaliveInterval))
+					conn.Close()
+					return
+				}
+				continue
+			}
+
+			isPending = true
+			go func() {
+				if _, _, err := conn.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+					channReply <- struct{}{}
+				}
+			}()
+		}
+	}
+}
+
+// sshConnErr returns the error of the given connection if it is lost.
+func sshConnErr(conn *ssh.Client) error {
+
+	if err, ok := sshConnErrs.Load(conn); ok == true {
+		return err.(error)
+	}
+
+	return nil
+}
+
+// sshTimeout returns the timeout by the given value (millisecond) and the default timeout.
+// Zero value means the default timeout and negative value means no timeout.
+func sshTimeout(val int64, def time.Duration) time.Duration {
+
+	if val == 0 {
+		return def
+	} else if val < 0 {
+		return 0
+	}
+
+	return time.Duration(val) * time.Millisecond
+}
@@ -0,0 +1,235 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains host key verification for ssh clients.
+//
+// References:
+//   known_hosts format : http://man.openbsd.org/sshd#SSH_KNOWN_HOSTS_FILE_FORMAT
+//   knownhosts pkg     : https://godoc.org/golang.org/x/crypto/ssh/knownhosts
+//
+// Policies:
+//   strict     : Host key must be known (known_hosts files or hostKey option).
+//   accept-new : Unknown host keys are added to the user known_hosts file.
+//                Changed host keys are refused.
+//   off        : No verification.
+
+package client
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	sshHostKeyPolicies = map[string]bool{"strict": true, "accept-new": true, "off": true}
+	sshKnownHostsSys   = "/etc/ssh/ssh_known_hosts" // system-wide known_hosts file
+	sshKnownHostsMu    sync.Mutex                   // mutex for the known_hosts files
+)
+
+// sshParseHostKey parses the given host key (public key or SHA256 fingerprint).
+// It returns nil for fingerprints.
+func sshParseHostKey(hostKey string) (ssh.PublicKey, error) {
+
+	if strings.HasPrefix(hostKey, "SHA256:") == true {
+		return nil, nil
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// sshHostKeyCallback returns the host key callback and the host key algorithms
+// (preferred by the known host keys if any) by the given address and options.
+// Host certificates are verified by the host CA keys if they are defined.
+func sshHostKeyCallback(addr string, cliOpts ClientOptions) (ssh.HostKeyCallback, []string, error) {
+
+	hkcb, algos, err := sshHostKeyKnown(addr, cliOpts)
+	if err != nil || cliOpts.HostKeyPolicy == "off" || cliOpts.HostCAKey == "" {
+		return hkcb, algos, err
+	}
+
+	return sshHostCertCallback(hkcb, algos, cliOpts)
+}
+
+// sshHostKeyKnown returns the host key callback and the host key algorithms by the
+// given address and options for the known host keys (hostKey option and known_hosts files).
+func sshHostKeyKnown(addr string, cliOpts ClientOptions) (ssh.HostKeyCallback, []string, error) {
+
+	// Off
+	if cliOpts.HostKeyPolicy == "off" {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	// Host key
+	if cliOpts.HostKey != "" {
+		key, err := sshParseHostKey(cliOpts.HostKey)
+		if err != nil {
+			return nil, nil, err
+		} else if key != nil {
+			return ssh.FixedHostKey(key), sshKeyAlgos([]string{key.Type()}), nil
+		}
+
+		fp := cliOpts.HostKey
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != fp {
+				return errors.New("host key mismatch (" + ssh.FingerprintSHA256(key) + ")")
+			}
+			return nil
+		}, nil, nil
+	}
+
+	// Known hosts files
+	userFile, files, err := sshKnownHostsFiles(cliOpts.KnownHostsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var khcb ssh.HostKeyCallback
+	if files != nil {
+		if khcb, err = knownhosts.New(files...); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Prefer the algorithms of the known host keys
+	var algos []string
+	if khcb != nil {
+		probeKey, _ := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+		var keyErr *knownhosts.KeyError
+		if errors.As(khcb(addr, sshRemoteAddr(addr), probeKey), &keyErr) == true {
+			var types []string
+			for _, kk := range keyErr.Want {
+				types = append(types, kk.Key.Type())
+			}
+			algos = sshKeyAlgos(types)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+
+		// Check the known hosts
+		err := error(&knownhosts.KeyError{})
+		if khcb != nil {
+			if err = khcb(hostname, remote, key); err == nil {
+				return nil
+			}
+		}
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) == false {
+			return err // i.e. revoked key
+		} else if len(keyErr.Want) > 0 {
+			return errors.New("host key mismatch (" + ssh.FingerprintSHA256(key) + "), the host key is changed or someone is doing something nasty")
+		}
+
+		// Unknown host
+		if cliOpts.HostKeyPolicy == "accept-new" {
+			return sshKnownHostsAdd(userFile, hostname, remote, key)
+		}
+
+		return errors.New("unknown host key (" + ssh.FingerprintSHA256(key) + "), add it to " + userFile + " or use accept-new host key policy")
+	}, algos, nil
+}
+
+// sshKnownHostsFiles returns the user known_hosts file and the existing known_hosts files
+// by the given known_hosts file. Default; HOME/.ssh/known_hosts and /etc/ssh/ssh_known_hosts
+func sshKnownHostsFiles(file string) (string, []string, error) {
+
+	// Init vars
+	var files []string
+
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil, errors.New("HOME couldn't be determined: " + err.Error())
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+
+		if _, err := os.Stat(sshKnownHostsSys); err == nil {
+			files = append(files, sshKnownHostsSys)
+		}
+	} else {
+		file = expandHome(file)
+	}
+
+	if _, err := os.Stat(file); err == nil {
+		files = append(files, file)
+	}
+
+	return file, files, nil
+}
+
+// sshKnownHostsAdd adds the given host key to the given known_hosts file.
+func sshKnownHostsAdd(file, hostname string, remote net.Addr, key ssh.PublicKey) error {
+
+	sshKnownHostsMu.Lock()
+	defer sshKnownHostsMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return errors.New("failed to add the host key: " + err.Error())
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.New("failed to add the host key: " + err.Error())
+	}
+	defer f.Close()
+
+	addrs := []string{knownhosts.Normalize(hostname)}
+	if remote != nil && knownhosts.Normalize(remote.String()) != addrs[0] {
+		addrs = append(addrs, knownhosts.Normalize(remote.String()))
+	}
+
+	if _, err := f.WriteString(knownhosts.Line(addrs, key) + "\n"); err != nil {
+		return errors.New("failed to add the host key: " + err.Error())
+	}
+
+	return nil
+}
+
+// sshKeyAlgos returns the host key algorithms by the given key types.
+func sshKeyAlgos(types []string) []string {
+
+	var algos []string
+	founds := make(map[string]bool)
+
+	for _, t := range types {
+		list := []string{t}
+		if t == ssh.KeyAlgoRSA {
+			list = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+		}
+		for _, algo := range list {
+			if founds[algo] == false {
+				algos = append(algos, algo)
+				founds[algo] = true
+			}
+		}
+	}
+
+	return algos
+}
+
+// sshRemoteAddr returns the remote address by the given address if it is an IP address.
+func sshRemoteAddr(addr string) net.Addr {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			return &net.TCPAddr{IP: ip}
+		}
+	}
+
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
@@ -0,0 +1,63 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"context"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSSHHostKeyPolicy checks the host key policies by the test server.
+func TestSSHHostKeyPolicy(t *testing.T) {
+
+	addr := testServer(t)
+	dir := t.TempDir()
+
+	// Executes a command by the given client name, policy and known_hosts file
+	exec := func(name, policy, file string) Result {
+		cli := testClient(t, name, addr, ClientOptions{HostKeyPolicy: policy, KnownHostsFile: file})
+		return cli.Exec(context.Background(), "echo ok", ExecOptions{})
+	}
+
+	// Strict (unknown host key)
+	file := filepath.Join(dir, "known_hosts")
+	if res := exec("test_hk_strict", "strict", file); res.Err == nil || strings.Contains(res.Err.Error(), "unknown host key") == false {
+		t.Fatalf("strict: got error %v, want unknown host key", res.Err)
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) == false {
+		t.Fatalf("strict: known_hosts file is created: %v", err)
+	}
+
+	// Accept new (the host key is added and then it is known)
+	if res := exec("test_hk_accept", "accept-new", file); res.Err != nil {
+		t.Fatalf("accept-new: unexpected error: %v", res.Err)
+	}
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.HasPrefix(string(buf), knownhosts.Normalize(addr)+" ssh-ed25519 ") == false {
+		t.Fatalf("accept-new: unexpected known_hosts file: %q", buf)
+	}
+	if res := exec("test_hk_known", "strict", file); res.Err != nil {
+		t.Fatalf("strict: unexpected error for the known host: %v", res.Err)
+	}
+
+	// Mismatch
+	key := testSigner(t).PublicKey()
+	file = filepath.Join(dir, "known_hosts_other")
+	if err := os.WriteFile(file, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i, policy := range []string{"strict", "accept-new"} {
+		if res := exec("test_hk_mismatch"+strconv.Itoa(i), policy, file); res.Err == nil || strings.Contains(res.Err.Error(), "host key mismatch") == false {
+			t.Fatalf("%s: got error %v, want host key mismatch", policy, res.Err)
+		}
+	}
+}
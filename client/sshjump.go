@@ -0,0 +1,223 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains jump host (ProxyJump) support for ssh clients.
+// The connections to the remote systems are tunneled through the direct-tcpip
+// channels of the jump hosts.
+//
+// References:
+//   direct-tcpip : https://tools.ietf.org/html/rfc4254#section-7.2
+//   ProxyJump    : http://man.openbsd.org/ssh_config#ProxyJump
+
+package client
+
+import (
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	sshJumps   = map[string]*sshJump{} // jump host connections by their chains
+	sshJumpsMu sync.Mutex              // mutex for the jump host connections
+)
+
+// sshJump implements a shared jump host connection.
+type sshJump struct {
+	mu   sync.Mutex  // mutex for the connection
+	conn *ssh.Client // jump host connection
+}
+
+// sshJumpHops returns the jump hosts by the given ProxyJump value.
+func sshJumpHops(proxyJump string) []string {
+
+	if proxyJump == "" {
+		return nil
+	}
+
+	hops := strings.Split(proxyJump, ",")
+	for i, hop := range hops {
+		hops[i] = strings.TrimSpace(hop)
+	}
+
+	return hops
+}
+
+// sshJumpHop implements a resolved jump host.
+type sshJumpHop struct {
+	hop  string // jump host as it is given
+	addr string
+	auth ClientAuth
+	opts ClientOptions
+}
+
+// sshJumpTrim returns the jump hosts of the given ProxyJump value before the given
+// client name. A ProxyJump which is set for all the clients (i.e. -J option) includes
+// the jump host clients themselves, they are reached through the previous hops only.
+func sshJumpTrim(proxyJump, name string) string {
+
+	hops := sshJumpHops(proxyJump)
+	for i, hop := range hops {
+		if hop == name {
+			return strings.Join(hops[:i], ",")
+		}
+	}
+
+	return proxyJump
+}
+
+// sshJumpHost returns the address, authentication information and options of
+// the given jump host by the given options of the client.
+// Jump host can be the name of a ssh client or `[user@]host[:port]`. The latter uses
+// ssh_config and the defaults of the current user (username, ssh-agent and the default
+// key files) for authentication, and the host key options of the client except HostKey.
+// ssh_config settings are applied to the jump hosts.
+func sshJumpHost(hop string, cliOpts ClientOptions) (string, ClientAuth, ClientOptions, error) {
+
+	// Client
+	if cli, err := ByName(hop); err == nil {
+		jumpSSH, ok := cli.(*sshClient)
+		if ok == false {
+			return "", ClientAuth{}, ClientOptions{}, errors.New("it is not a ssh client")
+		}
+		return sshConfigApply(jumpSSH.addr, jumpSSH.auth, jumpSSH.opts)
+	}
+
+	// Address
+	jumpSSH := &sshClient{opts: cliOpts}
+	jumpSSH.opts.HostKey = ""   // belongs to the client
+	jumpSSH.opts.ProxyJump = "" // by ssh_config
+
+	if strings.Contains(hop, "@") == true {
+		spl := strings.SplitN(hop, "@", 2)
+		jumpSSH.auth.Username = spl[0]
+		hop = spl[1]
+	}
+	if err := jumpSSH.SetAddr(hop); err != nil {
+		return "", ClientAuth{}, ClientOptions{}, err
+	}
+
+	jumpSSH.auth.Agent = os.Getenv("SSH_AUTH_SOCK") != ""
+	jumpSSH.auth.DefaultKeyfiles = true
+
+	return sshConfigApply(jumpSSH.addr, jumpSSH.auth, jumpSSH.opts)
+}
+
+// sshJumpResolve returns the jump hosts by the given ProxyJump value and options of
+// the client. Like OpenSSH, the first jump host is connected by its own ProxyJump
+// (resolved recursively) and the others are connected through the previous ones.
+// seen has the jump hosts (`user@host:port`) of the current chain for loop detection.
+func sshJumpResolve(proxyJump string, cliOpts ClientOptions, seen map[string]bool) ([]sshJumpHop, error) {
+
+	var hops []sshJumpHop
+
+	for i, hop := range sshJumpHops(proxyJump) {
+
+		addr, auth, opts, err := sshJumpHost(hop, cliOpts)
+		if err != nil {
+			return nil, errors.New("invalid jump host (" + hop + "): " + err.Error())
+		}
+
+		key := auth.Username + "@" + addr
+		if seen[key] == true {
+			return nil, errors.New("invalid jump host (" + hop + "): jump host loop")
+		}
+
+		if i == 0 {
+			opts.ProxyJump = sshJumpTrim(opts.ProxyJump, hop)
+		}
+		if i == 0 && opts.ProxyJump != "" {
+			seen[key] = true
+			prevHops, err := sshJumpResolve(opts.ProxyJump, cliOpts, seen)
+			delete(seen, key)
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, prevHops...)
+		}
+
+		hops = append(hops, sshJumpHop{hop: hop, addr: addr, auth: auth, opts: opts})
+	}
+
+	return hops, nil
+}
+
+// sshJumpConn returns the connection of the last jump host by the given jump hosts,
+// target (`user@host:port`) and options of the client.
+// Jump hosts are connected in order and each one is connected through the previous one.
+// The connections are shared by the clients (i.e. one jump host connection for
+// all the clients of a parallel execution).
+func sshJumpConn(proxyJump, target string, cliOpts ClientOptions) (*ssh.Client, error) {
+
+	// Init vars
+	var conn *ssh.Client
+	chain := ""
+
+	hops, err := sshJumpResolve(proxyJump, cliOpts, map[string]bool{target: true})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hop := range hops {
+
+		chain += hop.auth.Username + "@" + hop.addr + ","
+
+		// Shared connection
+		sshJumpsMu.Lock()
+		jump := sshJumps[chain]
+		if jump == nil {
+			jump = &sshJump{}
+			sshJumps[chain] = jump
+		}
+		sshJumpsMu.Unlock()
+
+		jump.mu.Lock()
+		if jump.conn == nil {
+			sshConf, err := sshConfig(hop.addr, hop.auth, hop.opts)
+			if err == nil {
+				jump.conn, err = sshDial(conn, hop.addr, sshConf, hop.opts)
+			}
+			if err != nil {
+				jump.mu.Unlock()
+				return nil, errors.New("failed to connect the jump host (" + hop.hop + "): " + err.Error())
+			}
+			go sshJumpWait(jump, jump.conn)
+		}
+		conn = jump.conn
+		jump.mu.Unlock()
+	}
+
+	return conn, nil
+}
+
+// sshJumpWait waits for the given jump host connection and removes it when it is closed.
+func sshJumpWait(jump *sshJump, conn *ssh.Client) {
+
+	conn.Wait()
+	sshConnErrs.Delete(conn)
+
+	jump.mu.Lock()
+	if jump.conn == conn {
+		jump.conn = nil
+	}
+	jump.mu.Unlock()
+}
+
+// sshJumpCloseAll closes all the jump host connections.
+func sshJumpCloseAll() {
+
+	sshJumpsMu.Lock()
+	defer sshJumpsMu.Unlock()
+
+	for _, jump := range sshJumps {
+		jump.mu.Lock()
+		if jump.conn != nil {
+			jump.conn.Close()
+			jump.conn = nil
+		}
+		jump.mu.Unlock()
+	}
+}
@@ -0,0 +1,107 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestSSHJumpResolve checks the jump host chains of the named clients and the addresses.
+func TestSSHJumpResolve(t *testing.T) {
+
+	// Named clients (jump_a -> jump_b -> jump_c, jump_x <-> jump_y)
+	for _, c := range []struct{ name, addr, proxyJump string }{
+		{"jump_a", "a.example.com", "jump_b"},
+		{"jump_b", "b.example.com", "jump_c"},
+		{"jump_c", "c.example.com", ""},
+		{"jump_x", "x.example.com", "jump_y"},
+		{"jump_y", "y.example.com", "jump_x"},
+		{"jump_self", "self.example.com", "u@self.example.com"},
+	} {
+		cli, err := New("ssh", c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cli.Close()
+		cli.SetAddr(c.addr)
+		cli.SetAuth(ClientAuth{Username: "u", Password: "secret"})
+		if err := cli.SetOptions(ClientOptions{SSHConfigFile: "none", ProxyJump: c.proxyJump}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cliOpts := ClientOptions{SSHConfigFile: "none", HostKey: "SHA256:target"}
+	tests := []struct {
+		proxyJump string
+		want      string // hop addresses (comma separated) or error
+	}{
+		{"jump_c", "c.example.com:22"},
+		{"jump_a", "c.example.com:22,b.example.com:22,a.example.com:22"},
+		{"jump_c,jump_a", "c.example.com:22,a.example.com:22"}, // later hops through the previous ones
+		{"bastion:2222,jump_b", "bastion:2222,b.example.com:22"},
+		{"jump_x", "error: jump host loop"},
+		{"jump_self", "error: jump host loop"},
+		{"u@target.example.com", "error: jump host loop"},
+	}
+
+	for _, test := range tests {
+		hops, err := sshJumpResolve(test.proxyJump, cliOpts, map[string]bool{"u@target.example.com:22": true})
+		got := ""
+		if err != nil {
+			got = "error: " + err.Error()[strings.LastIndex(err.Error(), ": ")+2:]
+		} else {
+			var addrs []string
+			for _, hop := range hops {
+				addrs = append(addrs, hop.addr)
+			}
+			got = strings.Join(addrs, ",")
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.proxyJump, got, test.want)
+		}
+	}
+
+	// Address hops don't use the auth information and the host key of the client
+	hops, err := sshJumpResolve("bastion", cliOpts, map[string]bool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hops[0].auth.Password != "" || hops[0].auth.Username != sshCurrentUser() || hops[0].opts.HostKey != "" {
+		t.Fatalf("unexpected auth or options: %+v %+v", hops[0].auth, hops[0].opts)
+	}
+}
+
+// TestSSHJumpGlobal executes commands through a named jump host when the jump host
+// is set for all the clients (i.e. pipe.json clients with -J option), so the jump
+// host client has itself as ProxyJump too.
+func TestSSHJumpGlobal(t *testing.T) {
+
+	// The jump host is a proxy of the server, so the target connection is
+	// distinguished from the jump host connection.
+	addr := testServer(t)
+	bastionAddr, _ := testProxy(t, addr)
+	cliOpts := ClientOptions{ProxyJump: "jump_bastion"}
+	bastion := testClient(t, "jump_bastion", bastionAddr, cliOpts)
+	web := testClient(t, "jump_web", addr, cliOpts)
+
+	hops, err := sshJumpResolve("jump_bastion", web.(*sshClient).opts, map[string]bool{"test@" + addr: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(hops) != 1 || hops[0].addr != bastionAddr {
+		t.Fatalf("unexpected hops: %+v", hops)
+	}
+
+	for _, cli := range []Client{web, bastion} {
+		out := new(strings.Builder)
+		res := cli.Exec(context.Background(), "echo ok", ExecOptions{Stdout: out})
+		if res.Err != nil {
+			t.Fatalf("%s: %v", cli.Name(), res.Err)
+		} else if out.String() != "ok\n" {
+			t.Fatalf("%s: unexpected output: %q", cli.Name(), out.String())
+		}
+	}
+}
@@ -0,0 +1,451 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains file transfer (scp) functions for ssh clients.
+//
+// References:
+//   scp protocol : https://web.archive.org/web/20170215184048/https://blogs.oracle.com/janp/entry/how_the_scp_protocol_works
+//
+// The remote side is `scp -t` (upload) or `scp -f` (download). Directories are
+// transferred recursively and the modes and the modification times are preserved.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Put uploads the given local file or directory (recursively) to the given remote path.
+// If the remote path is a directory then the file is uploaded into it.
+func (cliSSH *sshClient) Put(ctx context.Context, src, dst string) Result {
+
+	// Init vars
+	res := newResult(cliSSH.name)
+
+	// Check vars
+	if src == "" || dst == "" {
+		return res.done(ErrKindExec, errors.New("missing path"))
+	}
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return res.done(ErrKindExec, errors.New("failed to read: "+err.Error()))
+	}
+	srcName := filepath.Base(src)
+	if absSrc, err := filepath.Abs(src); err == nil {
+		srcName = filepath.Base(absSrc)
+	}
+
+	// Transfer
+	stats, err := cliSSH.scp(ctx, "scp -r -p -t -- "+scpPath(dst), func(w io.Writer, r *bufio.Reader, stats *scpStats) error {
+		return scpSend(w, r, src, srcName, srcInfo, stats)
+	})
+	res.Files, res.BytesOut = stats.files, stats.bytes
+	if err != nil {
+		if ctx.Err() != nil {
+			return res.done(ctxErr(ctx))
+		}
+		return res.done(scpErrKind(err), errors.New("failed to upload: "+err.Error()))
+	}
+
+	return res.done(ErrKindExec, nil)
+}
+
+// Get downloads the given remote file or directory (recursively) into the given
+// local directory. The local directory is created if it doesn't exist.
+func (cliSSH *sshClient) Get(ctx context.Context, src, dst string) Result {
+
+	// Init vars
+	res := newResult(cliSSH.name)
+
+	// Check vars
+	if src == "" || dst == "" {
+		return res.done(ErrKindExec, errors.New("missing path"))
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return res.done(ErrKindExec, errors.New("failed to create the directory: "+err.Error()))
+	}
+
+	// Transfer
+	stats, err := cliSSH.scp(ctx, "scp -r -p -f -- "+scpPath(src), func(w io.Writer, r *bufio.Reader, stats *scpStats) error {
+		return scpRecv(w, r, dst, stats)
+	})
+	res.Files, res.BytesOut = stats.files, stats.bytes
+	if err != nil {
+		if ctx.Err() != nil {
+			return res.done(ctxErr(ctx))
+		}
+		return res.done(scpErrKind(err), errors.New("failed to download: "+err.Error()))
+	}
+
+	return res.done(ErrKindExec, nil)
+}
+
+// scpStats implements the statistics of a file transfer.
+type scpStats struct {
+	files int   // number of the transferred files
+	bytes int64 // number of the transferred bytes
+}
+
+// scpConnErr implements the connection error of a file transfer.
+type scpConnErr struct {
+	err error
+}
+
+// Error returns the error message.
+func (e *scpConnErr) Error() string {
+	return e.err.Error()
+}
+
+// scpErrKind returns the error kind of the given file transfer error.
+func scpErrKind(err error) string {
+	if _, ok := err.(*scpConnErr); ok {
+		return ErrKindConnect
+	}
+	return ErrKindExec
+}
+
+// scp executes the given scp command on a new session and calls the given function
+// by stdin and stdout of the command. The session is closed when the context is done.
+func (cliSSH *sshClient) scp(ctx context.Context, scpCmd string, fn func(w io.Writer, r *bufio.Reader, stats *scpStats) error) (scpStats, error) {
+
+	// Init vars
+	var stats scpStats
+
+	if ctx.Err() != nil {
+		return stats, ctx.Err()
+	}
+
+	// Session
+	_, sess, err := cliSSH.newSession()
+	if err != nil {
+		return stats, &scpConnErr{errors.New("connection error: " + err.Error())}
+	}
+	defer cliSSH.sessionDone()
+	defer sess.Close()
+
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return stats, err
+	}
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return stats, err
+	}
+	var stderr bytes.Buffer
+	sess.Stderr = &stderr
+
+	// Start
+	if err := sess.Start(scpCmd); err != nil {
+		return stats, err
+	}
+
+	// Stop the transfer when the context is done
+	channDone := make(chan struct{})
+	defer close(channDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sess.Close()
+		case <-channDone:
+		}
+	}()
+
+	// Transfer
+	errTx := fn(stdin, bufio.NewReader(stdout), &stats)
+	stdin.Close()
+
+	// Wait
+	channWait := make(chan error, 1)
+	go func() {
+		channWait <- sess.Wait()
+	}()
+
+	var errWait error
+	select {
+	case errWait = <-channWait:
+	case <-time.After(sshCloseWait):
+		sess.Close()
+	}
+
+	// The protocol error is preferred since it has the message of the remote side
+	if errTx != nil {
+		return stats, errTx
+	} else if errWait != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stats, errors.New(msg)
+		}
+		return stats, errWait
+	}
+
+	return stats, nil
+}
+
+// scpPath returns the given remote path for the scp command.
+// `~/` prefix is removed since scp starts in the home directory.
+func scpPath(path string) string {
+
+	if path == "~" {
+		path = "."
+	} else if strings.HasPrefix(path, "~/") == true {
+		path = path[2:]
+		if path == "" {
+			path = "."
+		}
+	}
+
+	return shellQuote(path)
+}
+
+// scpAck reads the response of the remote side.
+func scpAck(r *bufio.Reader) error {
+
+	b, err := r.ReadByte()
+	if err != nil {
+		return errors.New("failed to read the response: " + err.Error())
+	} else if b == 0 {
+		return nil
+	}
+
+	msg, _ := r.ReadString('\n')
+	msg = strings.TrimSpace(msg)
+	if b != 1 && b != 2 {
+		msg = string(b) + msg
+	}
+	if msg == "" {
+		msg = "unknown error"
+	}
+
+	return errors.New(msg)
+}
+
+// scpSend sends the given local file or directory (recursively) by the given name.
+func scpSend(w io.Writer, r *bufio.Reader, path, name string, info os.FileInfo, stats *scpStats) error {
+
+	// Check the name
+	if name == "" || strings.ContainsAny(name, "/\n") == true {
+		return errors.New("invalid file name: " + name)
+	}
+
+	// Times
+	mtime := info.ModTime().Unix()
+	if _, err := fmt.Fprintf(w, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+		return err
+	} else if err := scpAck(r); err != nil {
+		return err
+	}
+
+	// Directory
+	if info.IsDir() == true {
+		if _, err := fmt.Fprintf(w, "D%04o 0 %s\n", info.Mode().Perm(), name); err != nil {
+			return err
+		} else if err := scpAck(r); err != nil {
+			return err
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return errors.New("failed to read: " + err.Error())
+		}
+		for _, entry := range entries {
+			entryPath := filepath.Join(path, entry.Name())
+			entryInfo, err := os.Stat(entryPath) // follow the symbolic links
+			if err != nil || (entryInfo.IsDir() == false && entryInfo.Mode().IsRegular() == false) {
+				continue // special files are skipped
+			}
+			if err := scpSend(w, r, entryPath, entry.Name(), entryInfo, stats); err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprint(w, "E\n"); err != nil {
+			return err
+		}
+		return scpAck(r)
+	}
+
+	// File
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.New("failed to read: " + err.Error())
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), name); err != nil {
+		return err
+	} else if err := scpAck(r); err != nil {
+		return err
+	}
+
+	n, err := io.CopyN(w, f, info.Size())
+	stats.bytes += n
+	if err != nil {
+		return errors.New("failed to send " + path + ": " + err.Error())
+	}
+
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	} else if err := scpAck(r); err != nil {
+		return err
+	}
+	stats.files++
+
+	return nil
+}
+
+// scpRecv receives the files and directories into the given local directory.
+func scpRecv(w io.Writer, r *bufio.Reader, dst string, stats *scpStats) error {
+
+	// Init vars
+	type scpDir struct {
+		path  string
+		mode  os.FileMode
+		mtime time.Time
+		atime time.Time
+	}
+	dirs := []scpDir{{path: dst}}
+	var mtime, atime time.Time
+	var errRemote error
+
+	ack := func() error {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+
+	if err := ack(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" {
+				break
+			}
+			return errors.New("failed to read the response: " + err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("invalid response")
+		}
+
+		switch line[0] {
+		case 1, 2:
+			// Warnings (i.e. missing file) and errors of the remote side
+			if errRemote == nil {
+				errRemote = errors.New(strings.TrimSpace(line[1:]))
+			}
+			if line[0] == 2 {
+				return errRemote
+			}
+
+		case 'T':
+			var ms, mus, as, aus int64
+			if _, err := fmt.Sscanf(line, "T%d %d %d %d", &ms, &mus, &as, &aus); err != nil {
+				return errors.New("invalid time: " + line)
+			}
+			mtime, atime = time.Unix(ms, mus*1000), time.Unix(as, aus*1000)
+			if err := ack(); err != nil {
+				return err
+			}
+
+		case 'C', 'D':
+			// Cmmmm SIZE NAME (names may have spaces)
+			spl := strings.SplitN(line[1:], " ", 3)
+			if len(spl) != 3 {
+				return errors.New("invalid response: " + line)
+			}
+			mode, errMode := strconv.ParseUint(spl[0], 8, 32)
+			size, errSize := strconv.ParseInt(spl[1], 10, 64)
+			name := spl[2]
+			if errMode != nil || errSize != nil || size < 0 {
+				return errors.New("invalid response: " + line)
+			}
+			if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") == true {
+				return errors.New("invalid file name: " + name)
+			}
+			path := filepath.Join(dirs[len(dirs)-1].path, name)
+
+			if line[0] == 'D' {
+				if err := os.MkdirAll(path, 0700); err != nil {
+					return errors.New("failed to create the directory: " + err.Error())
+				}
+				dirs = append(dirs, scpDir{path: path, mode: os.FileMode(mode).Perm(), mtime: mtime, atime: atime})
+				mtime, atime = time.Time{}, time.Time{}
+				if err := ack(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := scpRecvFile(w, r, path, os.FileMode(mode).Perm(), size, stats); err != nil {
+				return err
+			}
+			if mtime.IsZero() == false {
+				os.Chtimes(path, atime, mtime)
+			}
+			mtime, atime = time.Time{}, time.Time{}
+
+		case 'E':
+			if len(dirs) < 2 {
+				return errors.New("invalid response: " + line)
+			}
+			dir := dirs[len(dirs)-1]
+			dirs = dirs[:len(dirs)-1]
+			os.Chmod(dir.path, dir.mode)
+			if dir.mtime.IsZero() == false {
+				os.Chtimes(dir.path, dir.atime, dir.mtime)
+			}
+			if err := ack(); err != nil {
+				return err
+			}
+
+		default:
+			return errors.New("invalid response: " + line)
+		}
+	}
+
+	return errRemote
+}
+
+// scpRecvFile receives a file by the given path, mode and size.
+func scpRecvFile(w io.Writer, r *bufio.Reader, path string, mode os.FileMode, size int64, stats *scpStats) error {
+
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.New("failed to create the file: " + err.Error())
+	}
+
+	n, err := io.CopyN(f, r, size)
+	stats.bytes += n
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return errors.New("failed to receive " + path + ": " + err.Error())
+	}
+	os.Chmod(path, mode)
+
+	if err := scpAck(r); err != nil {
+		return err
+	}
+	stats.files++
+
+	_, err = w.Write([]byte{0})
+	return err
+}
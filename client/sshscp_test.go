@@ -0,0 +1,224 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSCPRecv checks the parsing of the records of the remote side.
+func TestSCPRecv(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		input     string            // records of the remote side (acks of the files included)
+		want      map[string]string // relative path -> content (`dir/` for directories)
+		wantModes map[string]os.FileMode
+		wantMtime map[string]int64
+		wantStats scpStats
+		wantErr   string
+	}{
+		{
+			name:      "file",
+			input:     "C0640 5 a.txt\nhello\x00",
+			want:      map[string]string{"a.txt": "hello"},
+			wantModes: map[string]os.FileMode{"a.txt": 0640},
+			wantStats: scpStats{files: 1, bytes: 5},
+		},
+		{
+			name:      "file with time and spaces",
+			input:     "T1400000000 0 1400000001 0\nC0600 3 my file.txt\nabc\x00",
+			want:      map[string]string{"my file.txt": "abc"},
+			wantMtime: map[string]int64{"my file.txt": 1400000000},
+			wantStats: scpStats{files: 1, bytes: 3},
+		},
+		{
+			name:      "directory",
+			input:     "T1400000000 0 1400000000 0\nD0750 0 dir\nC0644 1 x\nx\x00D0700 0 sub\nC0600 0 empty\n\x00E\nE\n",
+			want:      map[string]string{"dir/": "", "dir/x": "x", "dir/sub/": "", "dir/sub/empty": ""},
+			wantModes: map[string]os.FileMode{"dir": 0750, "dir/sub": 0700},
+			wantMtime: map[string]int64{"dir": 1400000000},
+			wantStats: scpStats{files: 2, bytes: 1},
+		},
+		{
+			name:    "warning",
+			input:   "\x01scp: missing: No such file or directory\n",
+			wantErr: "scp: missing: No such file or directory",
+		},
+		{
+			name:      "warning after file",
+			input:     "C0644 1 a\na\x00\x01scp: b: Permission denied\n",
+			want:      map[string]string{"a": "a"},
+			wantStats: scpStats{files: 1, bytes: 1},
+			wantErr:   "scp: b: Permission denied",
+		},
+		{
+			name:    "error",
+			input:   "\x02fatal\nC0644 1 a\na\x00",
+			wantErr: "fatal",
+		},
+		{
+			name:    "invalid name",
+			input:   "C0644 1 ../a\na\x00",
+			wantErr: "invalid file name: ../a",
+		},
+		{
+			name:    "invalid mode",
+			input:   "C0X44 1 a\na\x00",
+			wantErr: "invalid response: C0X44 1 a",
+		},
+		{
+			name:    "invalid time",
+			input:   "Tx\n",
+			wantErr: "invalid time: Tx",
+		},
+		{
+			name:    "unexpected end of directory",
+			input:   "E\n",
+			wantErr: "invalid response: E",
+		},
+		{
+			name:    "unknown record",
+			input:   "X\n",
+			wantErr: "invalid response: X",
+		},
+		{
+			name:      "short file",
+			input:     "C0644 10 a\nabc",
+			wantStats: scpStats{bytes: 3},
+			wantErr:   "EOF",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			dst := t.TempDir()
+			var stats scpStats
+			w := new(bytes.Buffer)
+			err := scpRecv(w, bufio.NewReader(strings.NewReader(test.input)), dst, &stats)
+
+			if test.wantErr != "" {
+				if err == nil || strings.HasSuffix(err.Error(), test.wantErr) == false {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if stats != test.wantStats {
+				t.Errorf("got stats %+v, want %+v", stats, test.wantStats)
+			}
+
+			for path, content := range test.want {
+				full := filepath.Join(dst, path)
+				if strings.HasSuffix(path, "/") == true {
+					if info, err := os.Stat(full); err != nil || info.IsDir() == false {
+						t.Errorf("%s: directory is not created", path)
+					}
+					continue
+				}
+				if buf, err := os.ReadFile(full); err != nil || string(buf) != content {
+					t.Errorf("%s: got %q (%v), want %q", path, buf, err, content)
+				}
+			}
+			for path, mode := range test.wantModes {
+				if info, err := os.Stat(filepath.Join(dst, path)); err != nil || info.Mode().Perm() != mode {
+					t.Errorf("%s: unexpected mode: %v", path, info.Mode())
+				}
+			}
+			for path, mtime := range test.wantMtime {
+				if info, err := os.Stat(filepath.Join(dst, path)); err != nil || info.ModTime().Unix() != mtime {
+					t.Errorf("%s: unexpected modification time: %v", path, info.ModTime())
+				}
+			}
+		})
+	}
+}
+
+// TestSCPSend checks the records of a directory and their round trip by scpRecv.
+func TestSCPSend(t *testing.T) {
+
+	// Local files
+	src := filepath.Join(t.TempDir(), "dir")
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	os.WriteFile(filepath.Join(src, "a b.txt"), []byte("hello"), 0640)
+	os.WriteFile(filepath.Join(src, "sub", "c"), nil, 0600)
+	mtime := time.Unix(1400000000, 0)
+	for _, path := range []string{filepath.Join(src, "a b.txt"), filepath.Join(src, "sub", "c"), filepath.Join(src, "sub"), src} {
+		os.Chtimes(path, mtime, mtime)
+	}
+	os.Chmod(src, 0750)
+
+	// Send (every record is acknowledged)
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats scpStats
+	w := new(bytes.Buffer)
+	acks := bufio.NewReader(bytes.NewReader(make([]byte, 64)))
+	if err := scpSend(w, acks, src, "dir", info, &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "T1400000000 0 1400000000 0\nD0750 0 dir\n" +
+		"T1400000000 0 1400000000 0\nC0640 5 a b.txt\nhello\x00" +
+		"T1400000000 0 1400000000 0\nD0755 0 sub\n" +
+		"T1400000000 0 1400000000 0\nC0600 0 c\n\x00" +
+		"E\nE\n"
+	if w.String() != want {
+		t.Fatalf("got %q, want %q", w.String(), want)
+	}
+	if stats != (scpStats{files: 2, bytes: 5}) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// Receive
+	dst := t.TempDir()
+	stats = scpStats{}
+	if err := scpRecv(new(bytes.Buffer), bufio.NewReader(w), dst, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(dst, "dir", "a b.txt")); err != nil || string(buf) != "hello" {
+		t.Fatalf("unexpected file: %q %v", buf, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "dir")); err != nil || info.Mode().Perm() != 0750 || info.ModTime().Equal(mtime) == false {
+		t.Fatalf("unexpected directory: %v", info)
+	}
+
+	// Remote errors
+	w.Reset()
+	if err := scpSend(w, bufio.NewReader(strings.NewReader("\x01scp: dir: Permission denied\n")), src, "dir", info, &stats); err == nil || err.Error() != "scp: dir: Permission denied" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := scpSend(w, acks, src, "a/b", info, &stats); err == nil {
+		t.Fatal("invalid name is accepted")
+	}
+}
+
+// TestSCPPath checks the remote paths of the scp commands.
+func TestSCPPath(t *testing.T) {
+
+	tests := map[string]string{
+		"~":          "'.'",
+		"~/":         "'.'",
+		"~/dir":      "'dir'",
+		"/tmp/a b":   "'/tmp/a b'",
+		"it's":       `'it'\''s'`,
+		"dir/~/file": "'dir/~/file'",
+	}
+
+	for path, want := range tests {
+		if got := scpPath(path); got != want {
+			t.Errorf("%s: got %s, want %s", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains script execution functions for ssh clients.
+//
+// A local script is uploaded (scp) into a temporary directory on the remote system,
+// executed by the interpreter of its shebang line (`sh` by default) and the temporary
+// directory is removed after the execution. The interpreter is executed explicitly
+// so the scripts can be executed even if the temporary directory is mounted by noexec.
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	sshScriptCleanupTimeout = 10 * time.Second // timeout of the temporary directory removal
+)
+
+// execScript uploads the local script (execOpts.Script) and executes it by the given
+// execution options and the script arguments (execOpts.ScriptArgs).
+func (cliSSH *sshClient) execScript(ctx context.Context, execOpts ExecOptions) Result {
+
+	// Init vars
+	res := newResult(cliSSH.name)
+
+	interp, err := scriptInterpreter(execOpts.Script)
+	if err != nil {
+		return res.done(ErrKindExec, errors.New("invalid script: "+err.Error()))
+	} else if ctx.Err() != nil {
+		return res.done(ctxErr(ctx))
+	}
+
+	// Temporary directory
+	// It must be readable by the become user (except root).
+	becomeUser := execOpts.Become
+	if becomeUser == "" {
+		becomeUser = cliSSH.opts.Become
+	}
+	tmpCmd := `d=$(mktemp -d "${TMPDIR:-/tmp}/yapi.XXXXXXXX") && `
+	if becomeUser != "" && becomeUser != "root" {
+		tmpCmd += `chmod 755 "$d" && `
+	}
+	tmpCmd += `echo "$d"`
+
+	tmpDir, err := cliSSH.run(ctx, tmpCmd)
+	if err != nil {
+		if ctx.Err() != nil {
+			return res.done(ctxErr(ctx))
+		}
+		return res.done(scpErrKind(err), errors.New("failed to create the temporary directory: "+err.Error()))
+	}
+	tmpDir = strings.TrimSpace(tmpDir)
+	if tmpDir == "" || strings.HasPrefix(tmpDir, "/") == false {
+		return res.done(ErrKindExec, errors.New("failed to create the temporary directory: invalid path ("+tmpDir+")"))
+	}
+
+	// Remove the temporary directory even if the context is done
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), sshScriptCleanupTimeout)
+		defer cancel()
+		cliSSH.run(cleanupCtx, "rm -rf -- "+shellQuote(tmpDir))
+	}()
+
+	// Upload
+	scriptPath := tmpDir + "/" + filepath.Base(execOpts.Script)
+	if putRes := cliSSH.Put(ctx, execOpts.Script, scriptPath); putRes.Err != nil {
+		putRes.Start = res.Start
+		putRes.Duration = putRes.End.Sub(putRes.Start)
+		return putRes
+	}
+	if becomeUser != "" && becomeUser != "root" {
+		if _, err := cliSSH.run(ctx, "chmod a+r "+shellQuote(scriptPath)); err != nil {
+			return res.done(ErrKindExec, errors.New("failed to upload: "+err.Error()))
+		}
+	}
+
+	// Execute
+	scriptCmd := interp + " " + shellQuote(scriptPath)
+	for _, arg := range execOpts.ScriptArgs {
+		scriptCmd += " " + shellQuote(arg)
+	}
+	execOpts.Script, execOpts.ScriptArgs = "", nil
+
+	scriptRes := cliSSH.Exec(ctx, scriptCmd, execOpts)
+	scriptRes.Start = res.Start
+	scriptRes.Duration = scriptRes.End.Sub(scriptRes.Start)
+
+	return scriptRes
+}
+
+// run executes the given command on a new session (without the client options)
+// and returns its output.
+func (cliSSH *sshClient) run(ctx context.Context, cliCmd string) (string, error) {
+
+	// Session
+	_, sess, err := cliSSH.newSession()
+	if err != nil {
+		return "", &scpConnErr{errors.New("connection error: " + err.Error())}
+	}
+	defer cliSSH.sessionDone()
+	defer sess.Close()
+
+	var stdout, stderr bytes.Buffer
+	sess.Stdout = &stdout
+	sess.Stderr = &stderr
+
+	if err := sess.Start(cliCmd); err != nil {
+		return "", err
+	}
+
+	// Wait
+	channWait := make(chan error, 1)
+	go func() {
+		channWait <- sess.Wait()
+	}()
+
+	select {
+	case err := <-channWait:
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return "", errors.New(msg)
+			}
+			return "", err
+		}
+	case <-ctx.Done():
+		sess.Close()
+		return "", ctx.Err()
+	}
+
+	return stdout.String(), nil
+}
+
+// scriptInterpreter returns the interpreter (shell quoted) of the given script file
+// by its shebang line (i.e. `#!/usr/bin/env python3`). Default; sh
+func scriptInterpreter(file string) (string, error) {
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && line == "" {
+		return "sh", nil // empty file
+	}
+
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#!") == false {
+		return "sh", nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, "#!"))
+	if len(fields) == 0 {
+		return "sh", nil
+	}
+	for i, field := range fields {
+		fields[i] = shellQuote(field)
+	}
+
+	return strings.Join(fields, " "), nil
+}
@@ -0,0 +1,181 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains terminal related functions for clients.
+// The controlling terminal is used instead of stdin since stdin can be piped data
+// for the client commands.
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	ttyMu      sync.Mutex // mutex for the terminal prompts
+	ttyRawMu   sync.Mutex // mutex for the raw mode
+	ttyRawDone func()     // function which restores the terminal from raw mode
+)
+
+// ttyPassword displays the given prompt on the controlling terminal and
+// reads a password (without echo).
+func ttyPassword(prompt string) (string, error) {
+	return ttyPrompt(prompt, false)
+}
+
+// ttyPrompt displays the given prompt on the controlling terminal and
+// reads a line (with or without echo).
+// It doesn't prompt if neither stdin nor stderr is a terminal (i.e. CI jobs),
+// so a non-interactive run fails instead of waiting for an answer.
+func ttyPrompt(prompt string, echo bool) (string, error) {
+
+	ttyMu.Lock()
+	defer ttyMu.Unlock()
+
+	if ttyInteractive() == false {
+		return "", errors.New("terminal is not available (non-interactive)")
+	}
+
+	// Open the terminal
+	ttyIn, ttyOut := "/dev/tty", "/dev/tty"
+	if runtime.GOOS == "windows" {
+		ttyIn, ttyOut = "CONIN$", "CONOUT$"
+	}
+
+	in, err := os.OpenFile(ttyIn, os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("terminal is not available: " + err.Error())
+	}
+	defer in.Close()
+
+	if term.IsTerminal(int(in.Fd())) == false {
+		return "", errors.New("terminal is not available")
+	}
+
+	var out io.Writer = in
+	if ttyOut != ttyIn {
+		if f, err := os.OpenFile(ttyOut, os.O_WRONLY, 0); err == nil {
+			defer f.Close()
+			out = f
+		} else {
+			out = os.Stderr
+		}
+	}
+
+	// Read the line
+	fmt.Fprint(out, prompt)
+	if echo == true {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && line == "" {
+			return "", errors.New("failed to read input: " + err.Error())
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	buf, err := term.ReadPassword(int(in.Fd()))
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", errors.New("failed to read password: " + err.Error())
+	}
+
+	return string(buf), nil
+}
+
+// ttyInteractive returns whether stdin or stderr is a terminal or not.
+func ttyInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) == true || term.IsTerminal(int(os.Stderr.Fd())) == true
+}
+
+// ttySize returns the size of the local terminal. Default; 80x24
+func ttySize() (int, int) {
+
+	for _, f := range []*os.File{os.Stdout, os.Stdin, os.Stderr} {
+		if term.IsTerminal(int(f.Fd())) == true {
+			if width, height, err := term.GetSize(int(f.Fd())); err == nil && width > 0 && height > 0 {
+				return width, height
+			}
+		}
+	}
+
+	return 80, 24
+}
+
+// ttyTerm returns the terminal type of the local terminal (TERM). Default; xterm
+func ttyTerm() string {
+
+	if val := os.Getenv("TERM"); val != "" {
+		return val
+	}
+
+	return "xterm"
+}
+
+// TTYInput returns a new reader of the local terminal (stdin) for a command execution.
+// The terminal is read by a single pump (see input.go) and the reader stops consuming
+// it when the execution is done.
+func TTYInput() io.Reader {
+
+	ttyInputOnce.Do(func() {
+		ttyInput = newInputPump(os.Stdin)
+	})
+
+	return ttyInput.reader()
+}
+
+// TTYRaw puts the local terminal (stdin) into raw mode and returns a function
+// which restores it. It returns an error if stdin is not a terminal.
+// The function is registered for TTYRestore until it is called.
+func TTYRaw() (func(), error) {
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) == false {
+		return nil, errors.New("stdin is not a terminal")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, errors.New("failed to put the terminal into raw mode: " + err.Error())
+	}
+
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			term.Restore(fd, state)
+		})
+	}
+
+	ttyRawMu.Lock()
+	ttyRawDone = restore
+	ttyRawMu.Unlock()
+
+	return func() {
+		restore()
+
+		ttyRawMu.Lock()
+		ttyRawDone = nil
+		ttyRawMu.Unlock()
+	}, nil
+}
+
+// TTYRestore restores the local terminal if it is in raw mode by TTYRaw
+// (i.e. before exiting).
+func TTYRestore() {
+
+	ttyRawMu.Lock()
+	restore := ttyRawDone
+	ttyRawDone = nil
+	ttyRawMu.Unlock()
+
+	if restore != nil {
+		restore()
+	}
+}
@@ -0,0 +1,39 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+//go:build !windows
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ttyResizeNotify calls the given function with the terminal size when the local
+// terminal is resized (SIGWINCH). It returns a function which stops the notification.
+func ttyResizeNotify(fn func(width, height int)) func() {
+
+	channSig := make(chan os.Signal, 1)
+	channDone := make(chan struct{})
+	signal.Notify(channSig, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-channSig:
+				fn(ttySize())
+			case <-channDone:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(channSig)
+		close(channDone)
+	}
+}
@@ -0,0 +1,10 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package client
+
+// ttyResizeNotify is not supported on Windows (no SIGWINCH).
+func ttyResizeNotify(fn func(width, height int)) func() {
+	return func() {}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/cmfatih/yapi/client"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
 )
 
@@ -20,29 +21,139 @@ type Conf struct {
 	isCliInited bool
 	filePath    string
 
-	Clients       []confClient `json:"clients"`
+	Clients       []confClient         `json:"clients"`
+	Defaults      confDefaults         `json:"defaults"`
+	Groups        map[string]confGroup `json:"groups"`
 	clientDefID   string
 	clientDefName string
+	clientOpts    client.ClientOptions
 }
 
 type confClient struct {
-	ID        string
-	Name      string         `json:"name"`
-	Groups    []string       `json:"groups"`
-	Kind      string         `json:"kind"`
-	Address   string         `json:"address"`
-	Auth      confClientAuth `json:"auth"`
-	IsDefault bool           `json:"isDefault"`
+	ID             string
+	Name           string         `json:"name"`
+	Groups         []string       `json:"groups"`
+	Kind           string         `json:"kind"`
+	Address        string         `json:"address"`
+	Auth           confClientAuth `json:"auth"`
+	IsDefault      bool           `json:"isDefault"`
+	HostKey        string         `json:"hostKey"`
+	KnownHostsFile string         `json:"knownHostsFile"`
+	HostKeyPolicy  string         `json:"hostKeyPolicy"`
+	HostCAKey      string         `json:"hostCAKey"`
+	ProxyJump      string         `json:"proxyJump"`
+	SSHConfigFile  string         `json:"sshConfigFile"`
+	IdleTimeout    int64          `json:"idleTimeout"`
+	TTY            bool           `json:"tty"`
+	Become         confBecome     `json:"become"`
+	confConn
+	confCmd
+}
+
+// confDefaults implements the default settings of the clients.
+type confDefaults struct {
+	confConn
+}
+
+// confBecome implements the privilege escalation (sudo) settings.
+type confBecome struct {
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	PasswordEnv string `json:"passwordEnv"`
+}
+
+// confGroup implements the settings of a client group.
+type confGroup struct {
+	confCmd
+}
+
+// confCmd implements the command settings.
+type confCmd struct {
+	Env     map[string]string `json:"env"`
+	Workdir string            `json:"workdir"`
+}
+
+// confConn implements the connection settings.
+type confConn struct {
+	ConnectTimeout    int64 `json:"connectTimeout"`
+	HandshakeTimeout  int64 `json:"handshakeTimeout"`
+	KeepaliveInterval int64 `json:"keepaliveInterval"`
+	KeepaliveCountMax int   `json:"keepaliveCountMax"`
 }
 
 type confClientAuth struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Keyfile  string `json:"keyfile"`
+	Username        string   `json:"username"`
+	Password        string   `json:"password"`
+	Keyfile         string   `json:"keyfile"`
+	Keyfiles        []string `json:"keyfiles"`
+	Passphrase      string   `json:"passphrase"`
+	PassphraseEnv   string   `json:"passphraseEnv"`
+	Agent           bool     `json:"agent"`
+	DefaultKeyfiles bool     `json:"defaultKeyfiles"`
 }
 
+// merge sets the unset settings by the given settings.
+func (cc *confConn) merge(def confConn) {
+	if cc.ConnectTimeout == 0 {
+		cc.ConnectTimeout = def.ConnectTimeout
+	}
+	if cc.HandshakeTimeout == 0 {
+		cc.HandshakeTimeout = def.HandshakeTimeout
+	}
+	if cc.KeepaliveInterval == 0 {
+		cc.KeepaliveInterval = def.KeepaliveInterval
+	}
+	if cc.KeepaliveCountMax == 0 {
+		cc.KeepaliveCountMax = def.KeepaliveCountMax
+	}
+}
+
+// cmdSettings returns the command settings (environment variables and working directory)
+// of the given client. The settings of the groups are applied in order of the client groups
+// and the settings of the client take precedence.
+func (conf *Conf) cmdSettings(cliConf confClient) ([]string, string) {
+
+	// Init vars
+	env := map[string]string{}
+	workdir := ""
+
+	cmdConfs := []confCmd{}
+	for _, group := range cliConf.Groups {
+		if groupConf, ok := conf.Groups[group]; ok == true {
+			cmdConfs = append(cmdConfs, groupConf.confCmd)
+		}
+	}
+	cmdConfs = append(cmdConfs, cliConf.confCmd)
+
+	for _, cmdConf := range cmdConfs {
+		for key, val := range cmdConf.Env {
+			env[key] = val
+		}
+		if cmdConf.Workdir != "" {
+			workdir = cmdConf.Workdir
+		}
+	}
+
+	// Environment variables in order of the keys
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var envList []string
+	for _, key := range keys {
+		envList = append(envList, key+"="+env[key])
+	}
+
+	return envList, workdir
+}
+
+// LoadOpt implements the load options.
+// CliOpts overwrites the client options of the configuration if they are set.
 type LoadOpt struct {
 	CliInit bool
+	CliOpts client.ClientOptions
 }
 
 // IsLoaded returns whether the configuration is loaded or not.
@@ -100,6 +211,7 @@ func (conf *Conf) Load(filePath string, opt LoadOpt) error {
 
 	conf.isLoaded = true
 	conf.filePath = filePath
+	conf.clientOpts = opt.CliOpts
 
 	if opt.CliInit == true {
 		if err := conf.CliInit(); err != nil {
@@ -127,6 +239,7 @@ func (conf *Conf) LoadJSON(jsonCont string, opt LoadOpt) error {
 	}
 
 	conf.isLoaded = true
+	conf.clientOpts = opt.CliOpts
 
 	if opt.CliInit == true {
 		if err := conf.CliInit(); err != nil {
@@ -167,13 +280,63 @@ func (conf *Conf) CliInit() error {
 
 		// Set auth
 		if err := cli.SetAuth(client.ClientAuth{
-			Username: cliConf.Auth.Username,
-			Password: cliConf.Auth.Password,
-			Keyfile:  cliConf.Auth.Keyfile,
+			Username:          cliConf.Auth.Username,
+			Password:          cliConf.Auth.Password,
+			Keyfile:           cliConf.Auth.Keyfile,
+			Keyfiles:          cliConf.Auth.Keyfiles,
+			Passphrase:        cliConf.Auth.Passphrase,
+			PassphraseEnv:     cliConf.Auth.PassphraseEnv,
+			Agent:             cliConf.Auth.Agent,
+			DefaultKeyfiles:   cliConf.Auth.DefaultKeyfiles,
+			BecomePassword:    cliConf.Become.Password,
+			BecomePasswordEnv: cliConf.Become.PasswordEnv,
 		}); err != nil {
 			return errors.New("error on client auth (index: " + strconv.Itoa(cliInd) + ", name: " + cliConf.Name + "): " + err.Error())
 		}
 
+		// Set options
+		cliOpts := client.ClientOptions{
+			HostKey:        cliConf.HostKey,
+			KnownHostsFile: cliConf.KnownHostsFile,
+			HostKeyPolicy:  cliConf.HostKeyPolicy,
+			HostCAKey:      cliConf.HostCAKey,
+			ProxyJump:      cliConf.ProxyJump,
+			SSHConfigFile:  cliConf.SSHConfigFile,
+			IdleTimeout:    cliConf.IdleTimeout,
+			TTY:            cliConf.TTY,
+			Become:         cliConf.Become.User,
+		}
+
+		// Command settings (client and groups in order)
+		cliOpts.Env, cliOpts.Workdir = conf.cmdSettings(cliConf)
+
+		// Connection settings (load options, client and defaults in order)
+		cliConn := confConn{
+			ConnectTimeout:    conf.clientOpts.ConnectTimeout,
+			HandshakeTimeout:  conf.clientOpts.HandshakeTimeout,
+			KeepaliveInterval: conf.clientOpts.KeepaliveInterval,
+			KeepaliveCountMax: conf.clientOpts.KeepaliveCountMax,
+		}
+		cliConn.merge(cliConf.confConn)
+		cliConn.merge(conf.Defaults.confConn)
+		cliOpts.ConnectTimeout = cliConn.ConnectTimeout
+		cliOpts.HandshakeTimeout = cliConn.HandshakeTimeout
+		cliOpts.KeepaliveInterval = cliConn.KeepaliveInterval
+		cliOpts.KeepaliveCountMax = cliConn.KeepaliveCountMax
+
+		if conf.clientOpts.HostKeyPolicy != "" {
+			cliOpts.HostKeyPolicy = conf.clientOpts.HostKeyPolicy
+		}
+		if conf.clientOpts.HostCAKey != "" {
+			cliOpts.HostCAKey = conf.clientOpts.HostCAKey
+		}
+		if conf.clientOpts.ProxyJump != "" {
+			cliOpts.ProxyJump = conf.clientOpts.ProxyJump
+		}
+		if err := cli.SetOptions(cliOpts); err != nil {
+			return errors.New("error on client options (index: " + strconv.Itoa(cliInd) + ", name: " + cliConf.Name + "): " + err.Error())
+		}
+
 		// Default client
 		if cliConf.IsDefault == true {
 			defCliID = cli.ID()
@@ -7,22 +7,30 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/cmfatih/yapi/client"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	cceMethods = map[string]bool{"serial": true, "parallel": true}
+	cceMethods       = map[string]bool{"serial": true, "parallel": true, "rolling": true}
+	cceTransferKinds = map[string]bool{"put": true, "get": true}
 )
 
 // cceWorker implements a CCE worker.
 type cceWorker struct {
-	id      string     // id
-	kind    string     // kind of worker (cce)
-	options CCEOptions // options
+	id      string          // id
+	kind    string          // kind of worker (cce)
+	options CCEOptions      // options
+	results []client.Result // results
 }
 
 // ID returns the unique id of the worker.
@@ -44,118 +52,442 @@ func (wCCE *cceWorker) SetOptions(workerOpts WorkerOptions) error {
 
 	if cceOpts.Clients == nil {
 		return errors.New("there is no any client to use")
-	} else if cceOpts.Cmd == "" {
+	} else if cceOpts.Cmd == "" && cceOpts.Transfer == nil && cceOpts.Script == "" {
 		return errors.New("missing client command")
+	} else if cceOpts.Transfer != nil && (cceTransferKinds[cceOpts.Transfer.Kind] != true || cceOpts.Transfer.Src == "" || cceOpts.Transfer.Dst == "") {
+		return errors.New("invalid file transfer")
 	} else if cceOpts.Method == "" || cceMethods[cceOpts.Method] != true {
 		return errors.New("invalid client command execution method (" + cceOpts.Method + ")")
 	}
 
+	if cceOpts.FanOut < 0 {
+		return errors.New("invalid fan-out limit (" + strconv.Itoa(cceOpts.FanOut) + ")")
+	} else if _, err := cceCount(cceOpts.BatchSize, 1); err != nil {
+		return errors.New("invalid batch size (" + cceOpts.BatchSize + ")")
+	} else if cceOpts.BatchPause < 0 {
+		return errors.New("invalid batch pause (" + strconv.FormatInt(cceOpts.BatchPause, 10) + ")")
+	} else if _, err := cceCount(cceOpts.MaxFail, 1); err != nil {
+		return errors.New("invalid failure threshold (" + cceOpts.MaxFail + ")")
+	}
+
+	if err := client.EnvCheck(cceOpts.Env); err != nil {
+		return err
+	}
+
+	if cceOpts.Output == "" {
+		cceOpts.Output = "raw" // default
+	} else if cceOutputs[cceOpts.Output] != true {
+		return errors.New("invalid client command output (" + cceOpts.Output + ")")
+	}
+
 	wCCE.options = cceOpts
 
 	return nil
 }
 
+// Results returns the results of the worker.
+// Putty of the results is a []client.Result in order of the clients.
+// It is available after the worker is completed.
+func (wCCE *cceWorker) Results() WorkerResults {
+	return WorkerResults{Putty: wCCE.results}
+}
+
 // Start starts the worker.
-func (wCCE *cceWorker) Start() error {
+// If the context is done or the timeout is reached then the running client commands
+// are stopped and the remaining ones are not executed.
+// If the context is canceled by a local signal (see client.WithSignal) then the signal
+// is delivered to the running client commands, the remaining ones are skipped and
+// the interrupted clients are reported.
+// If the failure threshold (MaxFail or FailFast) is reached then the remaining
+// client commands are skipped and the running ones are stopped (except the
+// rolling method which waits for the running batch).
+// It returns a *CCEError if any client command fails.
+func (wCCE *cceWorker) Start(ctx context.Context) error {
 
 	// Check the options
 	if len(wCCE.options.Clients) == 0 {
 		return errors.New("there is no client to work on")
 	}
 
-	// Because of the ssh pkg; this code block is the best approach so far.
-
-	// Init timeout vars
-	var timeout <-chan time.Time
+	// Init timeout
 	if wCCE.options.Timeout > 0 {
-		timeout = time.After(time.Duration(wCCE.options.Timeout) * time.Millisecond)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(wCCE.options.Timeout)*time.Millisecond)
+		defer cancel()
 	}
 
-	// Init sync
-	wg := new(sync.WaitGroup)
+	// Init results
 	cliCnt := len(wCCE.options.Clients)
-	wg.Add(cliCnt)
+	results := make([]client.Result, cliCnt)
+	wCCE.results = nil
 
-	if wCCE.options.Method == "serial" {
+	// Init output
+	output := newCCEOutput(wCCE.options)
 
-		// Init channel
-		channDone := make(chan bool)
+	// Init failure threshold
+	maxFail, _ := cceCount(wCCE.options.MaxFail, cliCnt)
+	if wCCE.options.FailFast == true {
+		maxFail = 1
+	} else if maxFail == 0 && wCCE.options.Method == "rolling" {
+		maxFail = 1 // default for rolling
+	}
 
-		go func() {
-			for _, name := range wCCE.options.Clients {
-				if err := client.ExecCmd(wCCE.options.Cmd, name); err != nil {
-					if wCCE.options.CmdErrPrint == true {
-						fmt.Println("failed to execute the command: " + err.Error())
-					}
-				}
-				wg.Done()
+	// Init abort
+	// The running client commands are stopped by the abort except the rolling method.
+	execCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	if wCCE.options.Method == "rolling" {
+		execCtx = ctx
+	}
+	failMu := new(sync.Mutex)
+	failCnt, aborted := 0, false
+
+	// Init pseudo-terminal
+	// Raw mode is used for a single client with raw output.
+	isRawTTY := wCCE.options.TTY == true && cliCnt == 1 && wCCE.options.Output == "raw"
+
+	// Checks the failure threshold by the given result
+	// The commands which are stopped by the abort (or the interrupt) are not counted.
+	checkFail := func(res client.Result) {
+		if res.Failed() == true && res.ErrKind != client.ErrKindCanceled {
+			failMu.Lock()
+			failCnt++
+			if maxFail > 0 && failCnt >= maxFail && aborted == false {
+				aborted = true
+				abort()
 			}
-			channDone <- true
-		}()
+			failMu.Unlock()
+		}
+	}
 
-		if wCCE.options.Timeout > 0 {
-			select {
-			case _ = <-channDone:
-				return nil
-			case <-timeout:
-				if wCCE.options.CmdErrPrint == true {
-					fmt.Println("failed to execute the command: timeout (" + fmt.Sprintf("%d", wCCE.options.Timeout) + "ms)")
-				}
-				return nil
+	// Executes the command by the given client index
+	execCmd := func(index int) {
+		cliName := wCCE.options.Clients[index]
+
+		// Check the abort
+		failMu.Lock()
+		isAborted := aborted
+		failMu.Unlock()
+
+		if isAborted == true || client.CtxSignal(ctx) != "" {
+			results[index] = client.Result{
+				Name:     cliName,
+				ExitCode: -1,
+				ErrKind:  client.ErrKindSkipped,
+				Err:      errors.New("skipped"),
 			}
+			output.done(index, results[index])
+			return
 		}
-	} else if wCCE.options.Method == "parallel" {
 
-		// Init channel
-		channDone := make(chan int)
+		// Transfer the files
+		if wCCE.options.Transfer != nil {
+			stdout, _ := output.writers(index, cliName)
+			res := cceTransfer(execCtx, wCCE.options.Transfer, cliName, stdout, wCCE.options.Output == "raw")
+			output.done(index, res)
+			if res.Err != nil && wCCE.options.CmdErrPrint == true {
+				fmt.Fprintln(os.Stderr, cceErrMsg(res, wCCE.options))
+			}
+			results[index] = res
+			checkFail(res)
+			return
+		}
 
-		go func() {
-			for i := 0; i < cliCnt; i++ {
-				go func(cliName string, index int) {
-					err := client.ExecCmd(wCCE.options.Cmd, cliName)
-					if err != nil {
-						if wCCE.options.CmdErrPrint == true {
-							fmt.Println("failed to execute the command: " + err.Error())
-						}
-					}
-					wg.Done()
-					channDone <- index + 1
-				}(wCCE.options.Clients[i], i)
+		// Execute the command
+		execOpts := client.HostExecOptions()
+		execOpts.Stdout, execOpts.Stderr = output.writers(index, cliName)
+		execOpts.TTY = wCCE.options.TTY
+		execOpts.Env = wCCE.options.Env
+		execOpts.Dir = wCCE.options.Dir
+		execOpts.Become = wCCE.options.Become
+		execOpts.Script = wCCE.options.Script
+		execOpts.ScriptArgs = wCCE.options.ScriptArgs
+
+		// The local terminal is put into raw mode for an interactive command
+		var restore func()
+		if isRawTTY == true {
+			if restore, _ = client.TTYRaw(); restore != nil && execOpts.Stdin == nil {
+				execOpts.Stdin = client.TTYInput()
 			}
-		}()
+		}
+
+		res := client.ExecCmdOpts(execCtx, wCCE.options.Cmd, cliName, execOpts)
+		if restore != nil {
+			restore()
+		}
+		output.done(index, res)
+		if res.Err != nil {
+			if wCCE.options.CmdErrPrint == true {
+				fmt.Fprintln(os.Stderr, cceErrMsg(res, wCCE.options))
+			}
+		}
+		results[index] = res
+		checkFail(res)
+	}
+
+	// Init client indexes
+	indexes := make([]int, cliCnt)
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	if wCCE.options.Method == "serial" {
+		cceRun(indexes, 1, execCmd)
+	} else if wCCE.options.Method == "parallel" {
+		cceRun(indexes, wCCE.options.FanOut, execCmd)
+	} else if wCCE.options.Method == "rolling" {
+		batchSize, _ := cceCount(wCCE.options.BatchSize, cliCnt)
+		if batchSize == 0 {
+			batchSize = 1 // default
+		}
+
+		for start := 0; start < cliCnt; start += batchSize {
+
+			// Pause between the batches
+			failMu.Lock()
+			isAborted := aborted
+			failMu.Unlock()
 
-		if wCCE.options.Timeout > 0 {
-			for {
+			if start > 0 && wCCE.options.BatchPause > 0 && isAborted == false {
 				select {
-				case val := <-channDone:
-					if val == cliCnt {
-						return nil
-					}
-				case <-timeout:
-					if wCCE.options.CmdErrPrint == true {
-						fmt.Println("failed to execute the command: timeout (" + fmt.Sprintf("%d", wCCE.options.Timeout) + "ms)")
-					}
-					return nil
+				case <-time.After(time.Duration(wCCE.options.BatchPause) * time.Millisecond):
+				case <-ctx.Done():
 				}
 			}
+
+			// Run the batch
+			end := start + batchSize
+			if end > cliCnt {
+				end = cliCnt
+			}
+			cceRun(indexes[start:end], wCCE.options.FanOut, execCmd)
 		}
 	} else {
-		if wCCE.options.CmdErrPrint == true {
-			fmt.Println("invalid client command execution method: " + wCCE.options.Method)
-			return nil
+		return errors.New("invalid client command execution method (" + wCCE.options.Method + ")")
+	}
+
+	output.close(results)
+
+	// Report the stopped and skipped clients
+	if aborted == true && wCCE.options.CmdErrPrint == true {
+		var stopped, skipped []string
+		for _, res := range results {
+			if res.ErrKind == client.ErrKindCanceled {
+				stopped = append(stopped, res.Name)
+			} else if res.ErrKind == client.ErrKindSkipped {
+				skipped = append(skipped, res.Name)
+			}
 		}
+		fmt.Fprintf(os.Stderr, "execution is aborted: %d failed client(s) (threshold: %d), %d stopped client(s): %s, %d skipped client(s): %s\n", failCnt, maxFail, len(stopped), strings.Join(stopped, ","), len(skipped), strings.Join(skipped, ","))
 	}
 
-	wg.Wait()
+	// Report the interrupted clients
+	if sig := client.CtxSignal(ctx); sig != "" && wCCE.options.CmdErrPrint == true {
+		var interrupted []string
+		skippedCnt := 0
+		for _, res := range results {
+			if res.ErrKind == client.ErrKindCanceled {
+				interrupted = append(interrupted, res.Name)
+			} else if res.ErrKind == client.ErrKindSkipped {
+				skippedCnt++
+			}
+		}
+		fmt.Fprintf(os.Stderr, "execution is interrupted (SIG%s): %d interrupted client(s): %s, %d skipped client(s)\n", sig, len(interrupted), strings.Join(interrupted, ","), skippedCnt)
+	}
 
-	return nil
+	wCCE.results = results
+
+	return cceResultsErr(results, aborted == true && client.CtxSignal(ctx) == "")
 }
 
 // CCEOptions implements the CCE options.
+// FanOut is the maximum number of the clients those run at the same time
+// by the parallel and rolling methods (0 means no limit).
+// BatchSize (N or N% of the clients, default 1) and BatchPause (millisecond) are
+// used by the rolling method.
+// The execution is aborted when the number of the failed clients reaches MaxFail
+// (N or N% of the clients, default no limit but 1 for the rolling method).
+// FailFast is same as MaxFail 1.
+// Output can be; raw (default), prefix, group, json or ndjson. Color is used by
+// the prefix and group outputs.
+// TTY requests a pseudo-terminal for the client commands. The local terminal is put
+// into raw mode if there is only one client and the output is raw.
+// Env (KEY=VALUE) and Dir are the environment variables and the working directory of
+// the client commands. They take precedence over the settings of the clients.
+// Become is the user of the client commands by sudo (it takes precedence over the
+// become settings of the clients).
+// Transfer transfers the files instead of executing the client command.
+// Script is a local script file which is uploaded and executed with ScriptArgs
+// instead of the client command.
 type CCEOptions struct {
 	Clients     []string
 	Cmd         string
 	CmdErrPrint bool
 	Method      string
 	Timeout     int64
+	FanOut      int
+	BatchSize   string
+	BatchPause  int64
+	MaxFail     string
+	FailFast    bool
+	Output      string
+	Color       bool
+	TTY         bool
+	Env         []string
+	Dir         string
+	Become      string
+	Transfer    *CCETransfer
+	Script      string
+	ScriptArgs  []string
+}
+
+// CCETransfer implements a file transfer of the CCE worker (instead of the client command).
+// Kind can be; put (uploads the local Src to the remote Dst) or get (downloads the remote Src
+// into the local Dst/CLIENTNAME directory).
+type CCETransfer struct {
+	Kind string
+	Src  string
+	Dst  string
+}
+
+// cceRun calls the given function for the given client indexes by a worker pool
+// which runs at most the given limit of clients at a time (0 means no limit).
+// The clients are started in order of the indexes.
+func cceRun(indexes []int, limit int, fn func(index int)) {
+
+	// Check the limit
+	if limit <= 0 || limit > len(indexes) {
+		limit = len(indexes)
+	}
+
+	// Init the pool
+	channIndex := make(chan int)
+	wg := new(sync.WaitGroup)
+	wg.Add(limit)
+
+	for i := 0; i < limit; i++ {
+		go func() {
+			for index := range channIndex {
+				fn(index)
+			}
+			wg.Done()
+		}()
+	}
+
+	for _, index := range indexes {
+		channIndex <- index
+	}
+	close(channIndex)
+
+	wg.Wait()
+}
+
+// CCEError implements the error of a CCE worker which has failed client commands.
+// Results contains the results of the failed client commands in order of the clients.
+// The client commands which are stopped by the abort (failure threshold) and
+// the skipped ones are not failures, they are counted by Stopped and Skipped.
+type CCEError struct {
+	Results []client.Result // results of the failed client commands
+	Stopped int             // number of the client commands which are stopped by the abort
+	Skipped int             // number of the skipped client commands
+}
+
+// Error returns the error message.
+func (cceErr *CCEError) Error() string {
+
+	msg := fmt.Sprintf("%d client command(s) failed", len(cceErr.Results))
+	if cceErr.Stopped > 0 {
+		msg += fmt.Sprintf(", %d stopped", cceErr.Stopped)
+	}
+	if cceErr.Skipped > 0 {
+		msg += fmt.Sprintf(", %d skipped", cceErr.Skipped)
+	}
+
+	return msg
+}
+
+// cceCount returns the count by the given value (N or N% of the total).
+// Percentages are rounded up and at least 1. Empty value returns 0.
+func cceCount(val string, total int) (int, error) {
+
+	if val == "" {
+		return 0, nil
+	}
+
+	isPct := strings.HasSuffix(val, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(val, "%"))
+	if err != nil || n < 1 || (isPct == true && n > 100) {
+		return 0, errors.New("invalid value (" + val + ")")
+	}
+
+	if isPct == true {
+		n = (total*n + 99) / 100
+		if n < 1 {
+			n = 1
+		}
+	}
+
+	return n, nil
+}
+
+// cceTransfer transfers the files by the given file transfer and client name, and
+// writes a summary to the given writer. The summary has the client name if withName
+// is true (i.e. raw output).
+func cceTransfer(ctx context.Context, transfer *CCETransfer, cliName string, w io.Writer, withName bool) client.Result {
+
+	var res client.Result
+	if transfer.Kind == "put" {
+		res = client.PutFiles(ctx, transfer.Src, transfer.Dst, cliName)
+	} else {
+		res = client.GetFiles(ctx, transfer.Src, filepath.Join(transfer.Dst, cliName), cliName)
+	}
+
+	if res.Err == nil && w != nil {
+		if withName == true {
+			fmt.Fprintf(w, "files are transferred (%s): %d file(s), %d byte(s)\n", cliName, res.Files, res.BytesOut)
+		} else {
+			fmt.Fprintf(w, "%d file(s), %d byte(s) transferred\n", res.Files, res.BytesOut)
+		}
+	}
+
+	return res
+}
+
+// cceErrMsg returns the error message by the given result and options.
+func cceErrMsg(res client.Result, cceOpts CCEOptions) string {
+
+	msg := "failed to execute the command (" + res.Name + "): "
+	if cceOpts.Transfer != nil {
+		msg = "failed to transfer the files (" + res.Name + "): "
+	}
+
+	if res.ErrKind == client.ErrKindTimeout {
+		return msg + "timeout (" + fmt.Sprintf("%d", cceOpts.Timeout) + "ms)"
+	}
+
+	return msg + res.Err.Error()
+}
+
+// cceResultsErr returns a *CCEError for the failed, stopped or skipped results if any.
+// The canceled results are stopped ones if the execution is aborted (otherwise
+// they are interrupted or timed out, so failed).
+func cceResultsErr(results []client.Result, isAborted bool) error {
+
+	// Check the results
+	cceErr := &CCEError{}
+	for _, res := range results {
+		if res.ErrKind == client.ErrKindSkipped {
+			cceErr.Skipped++
+		} else if res.ErrKind == client.ErrKindCanceled && isAborted == true {
+			cceErr.Stopped++
+		} else if res.Failed() == true {
+			cceErr.Results = append(cceErr.Results, res)
+		}
+	}
+
+	if cceErr.Results == nil && cceErr.Stopped == 0 && cceErr.Skipped == 0 {
+		return nil
+	}
+
+	return cceErr
 }
@@ -0,0 +1,55 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package worker
+
+import (
+	"errors"
+	"github.com/cmfatih/yapi/client"
+	"strings"
+	"testing"
+)
+
+// TestCCEResultsErr checks the failed, stopped and skipped results of the aborted and interrupted executions.
+func TestCCEResultsErr(t *testing.T) {
+
+	// Results
+	ok := client.Result{Name: "ok"}
+	failed := client.Result{Name: "failed", ExitCode: 3}
+	canceled := client.Result{Name: "canceled", ExitCode: -1, ErrKind: client.ErrKindCanceled, Err: errors.New("canceled")}
+	skipped := client.Result{Name: "skipped", ExitCode: -1, ErrKind: client.ErrKindSkipped, Err: errors.New("skipped")}
+
+	tests := []struct {
+		name      string
+		results   []client.Result
+		isAborted bool
+		want      string // names of the failed results (nil error: -)
+		wantMsg   string
+	}{
+		{"success", []client.Result{ok, ok}, false, "-", ""},
+		{"failure", []client.Result{ok, failed}, false, "failed", "1 client command(s) failed"},
+		{"abort", []client.Result{canceled, failed, skipped}, true, "failed", "1 client command(s) failed, 1 stopped, 1 skipped"},
+		{"interrupt", []client.Result{ok, canceled, skipped}, false, "canceled", "1 client command(s) failed, 1 skipped"},
+		{"interrupt before start", []client.Result{ok, skipped}, false, "", "0 client command(s) failed, 1 skipped"},
+	}
+
+	for _, test := range tests {
+		err := cceResultsErr(test.results, test.isAborted)
+		got, gotMsg := "-", ""
+		if err != nil {
+			gotMsg = err.Error()
+			var names []string
+			for _, res := range err.(*CCEError).Results {
+				names = append(names, res.Name)
+			}
+			got = strings.Join(names, ",")
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if gotMsg != test.wantMsg {
+			t.Errorf("%s: got message %q, want %q", test.name, gotMsg, test.wantMsg)
+		}
+	}
+}
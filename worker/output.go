@@ -0,0 +1,651 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+// This file contains output implementations for client command execution (CCE) worker.
+//
+// Outputs:
+//   raw    : Output of the clients are written to stdout and stderr of host as is.
+//   prefix : Every line is prefixed by the client name. Lines are never mixed between clients.
+//   group  : Output of the clients are buffered and identical outputs are displayed once
+//            with the list of the client names (like `dshbak -c`). Client names are
+//            compressed by numeric suffixes (i.e. web01,web02,web03 -> web[01-03]).
+//   json   : Results of the clients (including outputs) and a summary are displayed
+//            as a JSON document when all the client commands are completed.
+//   ndjson : Events are displayed as newline delimited JSON objects while the client
+//            commands are running; an event for every output line (stdout, stderr),
+//            an event for every client result (result) and a final event (summary).
+
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cmfatih/yapi/client"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outLineMax = 64 * 1024 // max size of a buffered line
+)
+
+var (
+	cceOutputs = map[string]bool{"raw": true, "prefix": true, "group": true, "json": true, "ndjson": true}
+
+	outColors    = []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"} // ANSI colors for client names
+	outColorErr  = "31"                                                                 // ANSI color for stderr marker
+	outMarkerErr = "[stderr]"                                                           // stderr marker
+	outGroupSep  = "----------------"                                                   // separator for group headers
+	outNameRegex = regexp.MustCompile(`^(.*?)([0-9]+)$`)                                // name with numeric suffix
+)
+
+// cceOutput is the interface that must be implemented by CCE outputs.
+type cceOutput interface {
+
+	// writers returns the stdout and stderr writers of the client by the given index.
+	writers(index int, cliName string) (io.Writer, io.Writer)
+
+	// done is called when the command of the client by the given index is completed.
+	done(index int, res client.Result)
+
+	// close is called when all the client commands are completed.
+	close(results []client.Result)
+}
+
+// newCCEOutput returns a new CCE output by the given options.
+func newCCEOutput(cceOpts CCEOptions) cceOutput {
+
+	if cceOpts.Output == "prefix" {
+		return &prefixOutput{
+			stdout: os.Stdout,
+			stderr: os.Stderr,
+			color:  cceOpts.Color,
+			mu:     new(sync.Mutex),
+			lws:    make(map[int][]*lineWriter),
+		}
+	}
+
+	if cceOpts.Output == "group" {
+		return &groupOutput{
+			stdout: os.Stdout,
+			color:  cceOpts.Color,
+			bufs:   make(map[int]*bytes.Buffer),
+		}
+	}
+
+	if cceOpts.Output == "json" {
+		return &jsonOutput{
+			stdout: os.Stdout,
+			bufs:   make(map[int][]*bytes.Buffer),
+		}
+	}
+
+	if cceOpts.Output == "ndjson" {
+		return &ndjsonOutput{
+			stdout: os.Stdout,
+			lws:    make(map[int][]*lineWriter),
+		}
+	}
+
+	return &rawOutput{stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// rawOutput implements the raw output.
+type rawOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// writers returns the stdout and stderr writers of the client by the given index.
+func (out *rawOutput) writers(index int, cliName string) (io.Writer, io.Writer) {
+	return out.stdout, out.stderr
+}
+
+// done is called when the command of the client by the given index is completed.
+func (out *rawOutput) done(index int, res client.Result) {}
+
+// close is called when all the client commands are completed.
+func (out *rawOutput) close(results []client.Result) {}
+
+// prefixOutput implements the prefix output.
+type prefixOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+	color  bool
+	mu     *sync.Mutex           // mutex for stdout and stderr (shared by the writers)
+	wmu    sync.Mutex            // mutex for lws
+	lws    map[int][]*lineWriter // line writers by client index
+}
+
+// writers returns the stdout and stderr writers of the client by the given index.
+func (out *prefixOutput) writers(index int, cliName string) (io.Writer, io.Writer) {
+
+	// Init prefixes
+	prefixOut := cliName + ": "
+	prefixErr := cliName + " " + outMarkerErr + ": "
+	if out.color == true {
+		color := outColors[index%len(outColors)]
+		prefixOut = outColorize(cliName, color) + ": "
+		prefixErr = outColorize(cliName, color) + " " + outColorize(outMarkerErr, outColorErr) + ": "
+	}
+
+	stdout := &lineWriter{emit: out.emitter(out.stdout, prefixOut)}
+	stderr := &lineWriter{emit: out.emitter(out.stderr, prefixErr)}
+
+	out.wmu.Lock()
+	out.lws[index] = []*lineWriter{stdout, stderr}
+	out.wmu.Unlock()
+
+	return stdout, stderr
+}
+
+// emitter returns a function which writes the lines with the given prefix.
+// All the lines are written at once so the lines of the clients are never mixed.
+func (out *prefixOutput) emitter(w io.Writer, prefix string) func([][]byte) error {
+	return func(lines [][]byte) error {
+		var buf []byte
+		for _, line := range lines {
+			buf = append(buf, prefix...)
+			buf = append(buf, line...)
+			buf = append(buf, '\n')
+		}
+
+		out.mu.Lock()
+		defer out.mu.Unlock()
+
+		_, err := w.Write(buf)
+		return err
+	}
+}
+
+// done is called when the command of the client by the given index is completed.
+func (out *prefixOutput) done(index int, res client.Result) {
+
+	out.wmu.Lock()
+	lws := out.lws[index]
+	delete(out.lws, index)
+	out.wmu.Unlock()
+
+	for _, lw := range lws {
+		lw.Flush()
+	}
+}
+
+// close is called when all the client commands are completed.
+func (out *prefixOutput) close(results []client.Result) {}
+
+// groupOutput implements the group output.
+type groupOutput struct {
+	stdout io.Writer
+	color  bool
+	mu     sync.Mutex            // mutex for bufs
+	bufs   map[int]*bytes.Buffer // output buffers by client index
+}
+
+// writers returns the stdout and stderr writers of the client by the given index.
+// Both of them write to the same buffer.
+func (out *groupOutput) writers(index int, cliName string) (io.Writer, io.Writer) {
+
+	buf := new(bytes.Buffer)
+
+	out.mu.Lock()
+	out.bufs[index] = buf
+	out.mu.Unlock()
+
+	w := &syncWriter{w: buf, mu: new(sync.Mutex)}
+
+	return w, w
+}
+
+// done is called when the command of the client by the given index is completed.
+func (out *groupOutput) done(index int, res client.Result) {}
+
+// close is called when all the client commands are completed.
+// It displays the identical outputs (with the same status) once with the client names
+// and the status in order of the clients.
+func (out *groupOutput) close(results []client.Result) {
+
+	// Group the outputs
+	type group struct {
+		output string
+		status string
+		names  []string
+	}
+	var groups []*group
+	keys := make(map[string]*group)
+
+	out.mu.Lock()
+	for i, res := range results {
+		buf := out.bufs[i]
+		if buf == nil {
+			continue // not executed
+		}
+		output, status := buf.String(), outGroupStatus(res)
+		key := status + "\x00" + output
+		g := keys[key]
+		if g == nil {
+			g = &group{output: output, status: status}
+			keys[key] = g
+			groups = append(groups, g)
+		}
+		g.names = append(g.names, res.Name)
+	}
+	out.mu.Unlock()
+
+	// Display
+	for _, g := range groups {
+		output := g.output
+		header := outNameRange(g.names)
+		if g.status != "" {
+			header += " (" + g.status + ")"
+		}
+		if out.color == true {
+			header = outColorize(header, outColors[0])
+		}
+		fmt.Fprintf(out.stdout, "%s\n%s\n%s\n", outGroupSep, header, outGroupSep)
+		io.WriteString(out.stdout, output)
+		if output != "" && strings.HasSuffix(output, "\n") == false {
+			io.WriteString(out.stdout, "\n")
+		}
+	}
+}
+
+// outGroupStatus returns the status of the given result for the group headers.
+// It is empty for the successful commands.
+func outGroupStatus(res client.Result) string {
+
+	if res.ErrKind != "" {
+		return "error: " + res.ErrKind
+	} else if res.Signal != "" {
+		return "signal: " + res.Signal
+	} else if res.ExitCode != 0 {
+		return "exit code: " + strconv.Itoa(res.ExitCode)
+	}
+
+	return ""
+}
+
+// syncWriter implements a writer which is safe for concurrent use.
+type syncWriter struct {
+	w  io.Writer
+	mu *sync.Mutex
+}
+
+// Write writes the given bytes to the underlying writer.
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	return sw.w.Write(p)
+}
+
+// jsonOutput implements the json output.
+type jsonOutput struct {
+	stdout io.Writer
+	mu     sync.Mutex              // mutex for bufs
+	bufs   map[int][]*bytes.Buffer // stdout and stderr buffers by client index
+}
+
+// writers returns the stdout and stderr writers of the client by the given index.
+func (out *jsonOutput) writers(index int, cliName string) (io.Writer, io.Writer) {
+
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+
+	out.mu.Lock()
+	out.bufs[index] = []*bytes.Buffer{stdout, stderr}
+	out.mu.Unlock()
+
+	return stdout, stderr
+}
+
+// done is called when the command of the client by the given index is completed.
+func (out *jsonOutput) done(index int, res client.Result) {}
+
+// close is called when all the client commands are completed.
+// It displays the results and the summary as a JSON document.
+func (out *jsonOutput) close(results []client.Result) {
+
+	doc := struct {
+		Results []outJSONResult `json:"results"`
+		Summary outJSONSummary  `json:"summary"`
+	}{
+		Results: []outJSONResult{},
+		Summary: newOutJSONSummary(results),
+	}
+
+	out.mu.Lock()
+	for i, res := range results {
+		jr := newOutJSONResult(res)
+		if bufs := out.bufs[i]; bufs != nil {
+			stdout, stderr := bufs[0].String(), bufs[1].String()
+			jr.Stdout, jr.Stderr = &stdout, &stderr
+		}
+		doc.Results = append(doc.Results, jr)
+	}
+	out.mu.Unlock()
+
+	buf, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to encode the results: "+err.Error())
+		return
+	}
+	out.stdout.Write(append(buf, '\n'))
+}
+
+// ndjsonOutput implements the ndjson output.
+type ndjsonOutput struct {
+	stdout io.Writer
+	mu     sync.Mutex            // mutex for stdout
+	wmu    sync.Mutex            // mutex for lws
+	lws    map[int][]*lineWriter // line writers by client index
+}
+
+// writers returns the stdout and stderr writers of the client by the given index.
+func (out *ndjsonOutput) writers(index int, cliName string) (io.Writer, io.Writer) {
+
+	stdout := &lineWriter{emit: out.emitter(cliName, "stdout")}
+	stderr := &lineWriter{emit: out.emitter(cliName, "stderr")}
+
+	out.wmu.Lock()
+	out.lws[index] = []*lineWriter{stdout, stderr}
+	out.wmu.Unlock()
+
+	return stdout, stderr
+}
+
+// emitter returns a function which writes an event for every line.
+func (out *ndjsonOutput) emitter(cliName, event string) func([][]byte) error {
+	return func(lines [][]byte) error {
+		for _, line := range lines {
+			if err := out.write(outJSONLine{
+				Event: event,
+				Name:  cliName,
+				Time:  time.Now(),
+				Line:  string(line),
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// done is called when the command of the client by the given index is completed.
+// It displays the result event of the client.
+func (out *ndjsonOutput) done(index int, res client.Result) {
+
+	out.wmu.Lock()
+	lws := out.lws[index]
+	delete(out.lws, index)
+	out.wmu.Unlock()
+
+	for _, lw := range lws {
+		lw.Flush()
+	}
+
+	jr := newOutJSONResult(res)
+	jr.Event = "result"
+	out.write(jr)
+}
+
+// close is called when all the client commands are completed.
+// It displays the summary event.
+func (out *ndjsonOutput) close(results []client.Result) {
+	js := newOutJSONSummary(results)
+	js.Event = "summary"
+	out.write(js)
+}
+
+// write writes the given event as a JSON line.
+func (out *ndjsonOutput) write(event interface{}) error {
+
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	_, err = out.stdout.Write(append(buf, '\n'))
+	return err
+}
+
+// outJSONResult implements the JSON object of a client result.
+// Duration is in milliseconds.
+type outJSONResult struct {
+	Event     string    `json:"event,omitempty"`
+	Name      string    `json:"name"`
+	Groups    []string  `json:"groups"`
+	Address   string    `json:"address"`
+	Kind      string    `json:"kind"`
+	ExitCode  int       `json:"exitCode"`
+	Signal    string    `json:"signal,omitempty"`
+	Stdout    *string   `json:"stdout,omitempty"`
+	Stderr    *string   `json:"stderr,omitempty"`
+	BytesOut  int64     `json:"bytesOut"`
+	BytesErr  int64     `json:"bytesErr"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  int64     `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+	ErrorKind string    `json:"errorKind,omitempty"`
+}
+
+// newOutJSONResult returns a new JSON object by the given client result.
+func newOutJSONResult(res client.Result) outJSONResult {
+
+	jr := outJSONResult{
+		Name:      res.Name,
+		Groups:    []string{},
+		ExitCode:  res.ExitCode,
+		Signal:    res.Signal,
+		BytesOut:  res.BytesOut,
+		BytesErr:  res.BytesErr,
+		Start:     res.Start,
+		End:       res.End,
+		Duration:  int64(res.Duration / time.Millisecond),
+		ErrorKind: res.ErrKind,
+	}
+
+	if res.Err != nil {
+		jr.Error = res.Err.Error()
+	}
+
+	if cli, err := client.ByName(res.Name); err == nil {
+		if cli.Groups() != nil {
+			jr.Groups = cli.Groups()
+		}
+		jr.Address = cli.Addr()
+		jr.Kind = cli.Kind()
+	}
+
+	return jr
+}
+
+// outJSONSummary implements the JSON object of the summary.
+type outJSONSummary struct {
+	Event     string `json:"event,omitempty"`
+	Total     int    `json:"total"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	Skipped   int    `json:"skipped"`
+}
+
+// newOutJSONSummary returns a new JSON object by the given client results.
+func newOutJSONSummary(results []client.Result) outJSONSummary {
+
+	js := outJSONSummary{Total: len(results)}
+
+	for _, res := range results {
+		if res.ErrKind == client.ErrKindSkipped {
+			js.Skipped++
+		} else if res.Failed() == true {
+			js.Failed++
+		} else {
+			js.Succeeded++
+		}
+	}
+
+	return js
+}
+
+// outJSONLine implements the JSON object of an output line.
+type outJSONLine struct {
+	Event string    `json:"event"`
+	Name  string    `json:"name"`
+	Time  time.Time `json:"time"`
+	Line  string    `json:"line"`
+}
+
+// lineWriter implements a line buffered writer.
+// Complete lines (without newlines) are passed to the emit function at once
+// and the incomplete line is kept until the next write or flush.
+type lineWriter struct {
+	emit func(lines [][]byte) error // emit function for the complete lines
+	buf  []byte                     // buffer for the incomplete line
+}
+
+// Write emits the complete lines and buffers the rest.
+func (lw *lineWriter) Write(p []byte) (int, error) {
+
+	lw.buf = append(lw.buf, p...)
+
+	// Split the complete lines
+	var lines [][]byte
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			if len(lw.buf) >= outLineMax {
+				// Too long line; emit it as a line
+				lines = append(lines, lw.buf)
+				lw.buf = nil
+			}
+			break
+		}
+		lines = append(lines, lw.buf[:i])
+		lw.buf = lw.buf[i+1:]
+	}
+
+	if lines != nil {
+		lw.buf = append([]byte(nil), lw.buf...)
+		if err := lw.emit(lines); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush emits the incomplete line if any.
+func (lw *lineWriter) Flush() error {
+
+	if len(lw.buf) == 0 {
+		return nil
+	}
+
+	line := lw.buf
+	lw.buf = nil
+
+	return lw.emit([][]byte{line})
+}
+
+// outColorize returns the given string with the given ANSI color.
+func outColorize(s, color string) string {
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, s)
+}
+
+// outNameRange returns the compressed list of the given client names.
+// Names which have the same prefix and number width are compressed into ranges.
+// Zero-padded numbers define the width and the other numbers with at least that many
+// digits join them (i.e. web08,web09,web10 -> web[08-10]).
+// i.e. web01,web02,web03,web05,db1 -> db1,web[01-03,05]
+func outNameRange(names []string) string {
+
+	// Init vars
+	var keys, list []string
+	nums := make(map[string][]int)
+	widths := make(map[string]int)
+	prefixes := make(map[string]string)
+
+	// Widths of the zero-padded numbers by prefix
+	padded := make(map[string][]int)
+	for _, name := range names {
+		if m := outNameRegex.FindStringSubmatch(name); m != nil && len(m[2]) > 1 && m[2][0] == '0' {
+			padded[m[1]] = append(padded[m[1]], len(m[2]))
+		}
+	}
+
+	for _, name := range names {
+		m := outNameRegex.FindStringSubmatch(name)
+		if m == nil {
+			list = append(list, name)
+			continue
+		}
+		num, err := strconv.Atoi(m[2])
+		if err != nil {
+			list = append(list, name)
+			continue
+		}
+
+		// The widest zero-padded width which fits the number (0 means no padding)
+		width := 0
+		for _, w := range padded[m[1]] {
+			if w <= len(m[2]) && w > width {
+				width = w
+			}
+		}
+
+		key := m[1] + "/" + strconv.Itoa(width)
+		if _, ok := nums[key]; !ok {
+			keys = append(keys, key)
+		}
+		nums[key] = append(nums[key], num)
+		widths[key] = width
+		prefixes[key] = m[1]
+	}
+
+	for _, key := range keys {
+		ns := nums[key]
+		sort.Ints(ns)
+
+		format := "%d"
+		if widths[key] > 0 {
+			format = "%0" + strconv.Itoa(widths[key]) + "d"
+		}
+
+		if len(ns) == 1 {
+			list = append(list, prefixes[key]+fmt.Sprintf(format, ns[0]))
+			continue
+		}
+
+		// Ranges
+		var ranges []string
+		for i := 0; i < len(ns); {
+			j := i
+			for j+1 < len(ns) && ns[j+1] <= ns[j]+1 {
+				j++
+			}
+			if ns[i] == ns[j] {
+				ranges = append(ranges, fmt.Sprintf(format, ns[i]))
+			} else {
+				ranges = append(ranges, fmt.Sprintf(format, ns[i])+"-"+fmt.Sprintf(format, ns[j]))
+			}
+			i = j + 1
+		}
+		list = append(list, prefixes[key]+"["+strings.Join(ranges, ",")+"]")
+	}
+
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
@@ -0,0 +1,93 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/cmfatih/yapi/client"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestOutNameRange checks the compressed lists of the client names.
+func TestOutNameRange(t *testing.T) {
+
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"web"}, "web"},
+		{[]string{"web01"}, "web01"},
+		{[]string{"web01", "web02", "web03"}, "web[01-03]"},
+		{[]string{"web01", "web02", "web03", "web05", "db1"}, "db1,web[01-03,05]"},
+		{[]string{"web05", "web01", "web03", "web02"}, "web[01-03,05]"},
+		{[]string{"web1", "web2", "web10", "web11"}, "web[1-2,10-11]"},
+		{[]string{"web1", "web2", "web01", "web02"}, "web[01-02],web[1-2]"}, // different widths
+		{[]string{"web9", "web10"}, "web[9-10]"},
+		{[]string{"web08", "web09", "web10"}, "web[08-10]"},
+		{outTestNames("web%02d", 1, 80), "web[01-80]"},
+		{outTestNames("web%03d", 99, 101), "web[099-101]"},
+		{[]string{"web01", "web2"}, "web01,web2"}, // the number doesn't fit the width
+		{[]string{"db", "web1", "app"}, "app,db,web1"},
+		{[]string{"1", "2", "3"}, "[1-3]"},
+		{[]string{"rack1-node1", "rack1-node2", "rack2-node1"}, "rack1-node[1-2],rack2-node1"},
+	}
+
+	for _, test := range tests {
+		if got := outNameRange(test.names); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.names, got, test.want)
+		}
+	}
+}
+
+// TestGroupOutput checks that the identical outputs are grouped by their status.
+func TestGroupOutput(t *testing.T) {
+
+	results := []client.Result{
+		{Name: "web01"},
+		{Name: "web02"},
+		{Name: "web03", ExitCode: 1},
+		{Name: "web04", ExitCode: 1},
+		{Name: "web05", ExitCode: 2},
+		{Name: "web06", ExitCode: -1, ErrKind: client.ErrKindConnect, Err: errors.New("refused")},
+		{Name: "web07", ExitCode: -1, Signal: "KILL"},
+	}
+	outputs := []string{"ok\n", "ok\n", "fail\n", "fail\n", "fail\n", "", "ok"}
+
+	stdout := new(bytes.Buffer)
+	out := &groupOutput{stdout: stdout, bufs: make(map[int]*bytes.Buffer)}
+	for i, res := range results {
+		w, _ := out.writers(i, res.Name)
+		io.WriteString(w, outputs[i])
+		out.done(i, res)
+	}
+	out.close(results)
+
+	want := []string{
+		outGroupSep, "web[01-02]", outGroupSep, "ok",
+		outGroupSep, "web[03-04] (exit code: 1)", outGroupSep, "fail",
+		outGroupSep, "web05 (exit code: 2)", outGroupSep, "fail",
+		outGroupSep, "web06 (error: connect)", outGroupSep,
+		outGroupSep, "web07 (signal: KILL)", outGroupSep, "ok",
+	}
+	if got := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// outTestNames returns the client names by the given format and number range.
+func outTestNames(format string, from, to int) []string {
+
+	var names []string
+	for i := from; i <= to; i++ {
+		names = append(names, fmt.Sprintf(format, i))
+	}
+
+	return names
+}
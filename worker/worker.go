@@ -7,6 +7,7 @@ package worker
 
 import (
 	"code.google.com/p/go-uuid/uuid"
+	"context"
 	"errors"
 )
 
@@ -27,8 +28,11 @@ type Worker interface {
 	// SetOptions sets the options of the worker.
 	SetOptions(workerOpts WorkerOptions) error
 
-	// Start starts the worker.
-	Start() error
+	// Start starts the worker. The work is stopped when the context is done.
+	Start(ctx context.Context) error
+
+	// Results returns the results of the worker.
+	Results() WorkerResults
 }
 
 // WorkerOptions implements the options of the worker.
@@ -37,6 +41,12 @@ type WorkerOptions struct {
 	Putty interface{}
 }
 
+// WorkerResults implements the results of the worker.
+// Putty represents worker's distinctive results.
+type WorkerResults struct {
+	Putty interface{}
+}
+
 // New returns a new worker with the given kind.
 func New(workerKind string) (Worker, error) {
 
@@ -63,8 +73,8 @@ func New(workerKind string) (Worker, error) {
 	return nil, errors.New("unexpected error! (worker.New)")
 }
 
-// Start starts the worker by the given worker id.
-func Start(workerID string) error {
+// Start starts the worker by the given context and worker id.
+func Start(ctx context.Context, workerID string) error {
 
 	// Get the worker
 	if workerID == "" || workers[workerID] == nil {
@@ -72,5 +82,5 @@ func Start(workerID string) error {
 	}
 
 	// Start the worker
-	return workers[workerID].Start()
+	return workers[workerID].Start(ctx)
 }
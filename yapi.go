@@ -6,6 +6,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -14,10 +15,12 @@ import (
 	"github.com/cmfatih/yapi/pipe"
 	"github.com/cmfatih/yapi/worker"
 	"os"
+	"os/signal"
 	"os/user"
 	"runtime"
 	"runtime/pprof"
 	"strings"
+	"syscall"
 )
 
 const (
@@ -30,18 +33,41 @@ var (
 	gvPipeConf  pipe.Conf // pipe config
 	gvCliNames  []string  // client names
 	gvCliGroups []string  // client groups
-
-	flPipeConf string // pipe config flag
-	flCliName  string // client name flag
-	flCliGroup string // client group flag
-	flCliCmd   string // client command flag
-	flCliCEM   string // client command execution method flag
-	flCliCET   int64  // client command execution timeout
-	flSSH      string // simple ssh client flag
-	flHelp     bool   // help flag
-	flVersion  bool   // version flag
-	flDbg      bool   // debug flag
-	flProfCPU  string // cpu profile flag
+	gvExitCode  int       // exit code
+	gvArgs      []string  // command line arguments (except the flags)
+
+	flPipeConf string   // pipe config flag
+	flCliName  string   // client name flag
+	flCliGroup string   // client group flag
+	flCliCmd   string   // client command flag
+	flCliCEM   string   // client command execution method flag
+	flCliCET   int64    // client command execution timeout
+	flCliCEC   string   // client command exit code scheme flag
+	flCliCFL   int      // client command fan-out limit flag
+	flCliCBS   string   // client command batch size flag
+	flCliCBP   int64    // client command batch pause flag
+	flCliCMF   string   // client command max failure flag
+	flCliCFF   bool     // client command fail fast flag
+	flCliCOF   string   // client command output format flag
+	flCliCOC   bool     // client command output color flag
+	flTTY      bool     // pseudo-terminal flag
+	flEnv      flagList // environment variable flags
+	flCwd      string   // working directory flag
+	flSudo     bool     // sudo flag
+	flBecome   string   // become user flag
+	flScript   string   // script flag
+	flSSH      string   // simple ssh client flag
+	flHostKey  string   // host key policy flag
+	flHostCA   string   // host CA key flag
+	flJump     string   // jump host flag
+	flConnTO   int64    // connect timeout flag
+	flHSTO     int64    // handshake timeout flag
+	flKAI      int64    // keepalive interval flag
+	flKACM     int      // keepalive count max flag
+	flHelp     bool     // help flag
+	flVersion  bool     // version flag
+	flDbg      bool     // debug flag
+	flProfCPU  string   // cpu profile flag
 )
 
 func init() {
@@ -63,8 +89,29 @@ func init() {
 	flag.StringVar(&flCliGroup, "cg", "", "Client group name(s) those will be connected.")
 	flag.StringVar(&flCliCEM, "ccem", "serial", "Execution method for client command. Default; serial")
 	flag.Int64Var(&flCliCET, "ccet", 0, "Timeout (millisecond) for client command execution.")
+	flag.StringVar(&flCliCEC, "ccec", "first", "Exit code scheme for failed client commands. Default; first")
+	flag.IntVar(&flCliCFL, "ccfl", 0, "Fan-out limit for parallel client command execution.")
+	flag.StringVar(&flCliCBS, "ccbs", "1", "Batch size (N or N%) for rolling client command execution. Default; 1")
+	flag.Int64Var(&flCliCBP, "ccbp", 0, "Pause (millisecond) between the batches of rolling execution.")
+	flag.StringVar(&flCliCMF, "ccmf", "", "Failure threshold (N or N%) for client command execution.")
+	flag.BoolVar(&flCliCFF, "ccff", false, "Stop client command execution on the first failure.")
+	flag.StringVar(&flCliCOF, "ccof", "raw", "Output format for client commands. Default; raw")
+	flag.BoolVar(&flCliCOC, "ccoc", false, "Colorize client names in the output.")
+	flag.BoolVar(&flTTY, "tty", false, "Request a pseudo-terminal for client commands.")
+	flag.Var(&flEnv, "env", "Environment variable (KEY=VALUE) for client commands.")
+	flag.StringVar(&flCwd, "cwd", "", "Working directory for client commands.")
+	flag.BoolVar(&flSudo, "sudo", false, "Execute client commands as root by sudo.")
+	flag.StringVar(&flBecome, "become", "", "Execute client commands as the given user by sudo.")
+	flag.StringVar(&flScript, "script", "", "Local script file that will be executed on the clients.")
 
 	flag.StringVar(&flSSH, "ssh", "", "Simple SSH client command execution.")
+	flag.StringVar(&flHostKey, "hostkey", "", "Host key policy for SSH clients. Default; strict")
+	flag.StringVar(&flHostCA, "hostca", "", "Host CA key (or file) for SSH host certificates.")
+	flag.StringVar(&flJump, "J", "", "Jump hosts for SSH clients.")
+	flag.Int64Var(&flConnTO, "ctimeout", 0, "Connect timeout (millisecond) for SSH clients. Default; 10000")
+	flag.Int64Var(&flHSTO, "htimeout", 0, "Handshake timeout (millisecond) for SSH clients. Default; 60000")
+	flag.Int64Var(&flKAI, "keepalive", 0, "Keepalive interval (millisecond) for SSH clients.")
+	flag.IntVar(&flKACM, "keepalivemax", 0, "Max number of unanswered keepalives for SSH clients. Default; 3")
 
 	flag.BoolVar(&flHelp, "help", false, "Display help and exit.")
 	flag.BoolVar(&flHelp, "h", false, "Display help and exit.")
@@ -75,20 +122,32 @@ func init() {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the app and returns the exit code.
+// The exit code is returned (instead of os.Exit) so the deferred functions run
+// and the panics reach the runtime.
+func run() int {
+
+	// Close the client connections
+	defer client.CloseAll()
 
 	// Init flags
-	flag.Parse()
+	gvArgs = flagParse()
 
 	// Profile cpu
 	if flProfCPU != "" {
 		f, err := os.Create(flProfCPU)
 		if err != nil {
-			fmt.Printf("Failed to profile cpu: %s\n", err)
-			return
+			fmt.Fprintf(os.Stderr, "Failed to profile cpu: %s\n", err)
+			gvExitCode = 255
+			return gvExitCode
 		}
 		if err := pprof.StartCPUProfile(f); err != nil {
-			fmt.Printf("Failed to profile cpu: %s\n", err)
-			return
+			fmt.Fprintf(os.Stderr, "Failed to profile cpu: %s\n", err)
+			gvExitCode = 255
+			return gvExitCode
 		}
 		defer pprof.StopCPUProfile()
 		//defer f.Close() // do not defer
@@ -97,52 +156,63 @@ func main() {
 	// Version
 	if flVersion == true {
 		flagVer()
-		return
+		return gvExitCode
 	}
 
 	// Help
 	if flHelp == true {
 		flagHelp()
-		return
+		return gvExitCode
 	}
 
 	// Debug
 	if flDbg == true {
 		flagDbg()
-		return
+		return gvExitCode
+	}
+
+	// File transfer (put LOCAL REMOTE or get REMOTE LOCALDIR)
+	// The arguments are the script arguments if there is a script.
+	var transfer *worker.CCETransfer
+	if len(gvArgs) > 0 && flScript == "" {
+		if (gvArgs[0] != "put" && gvArgs[0] != "get") || len(gvArgs) != 3 {
+			flagErr(errors.New("Invalid command: " + strings.Join(gvArgs, " ") + " (see -help)"))
+			return gvExitCode
+		}
+		transfer = &worker.CCETransfer{Kind: gvArgs[0], Src: gvArgs[1], Dst: gvArgs[2]}
 	}
 
 	// Simple SSH CCE
 	if flSSH != "" {
-		if err := flagSSH(flSSH, flCliCmd, flCliCEM, flCliCET); err != nil {
-			fmt.Println(err.Error())
+		if err := flagSSH(flSSH, flCliCmd, flCliCEM, flCliCET, transfer); err != nil {
+			flagErr(err)
 		}
-		return
+		return gvExitCode
 	}
 
 	// Client command
-	if flCliCmd != "" {
+	if flCliCmd != "" || transfer != nil || flScript != "" {
 		// pipe config
 		if err := flagPC(flPipeConf); err != nil {
-			fmt.Println(err.Error())
-			return
+			flagErr(err)
+			return gvExitCode
 		}
 
 		// client names and groups
 		flagCNG(flCliName, flCliGroup)
 
 		// client command
-		if err := flagCC(flCliCmd, flCliCEM, flCliCET, gvCliNames); err != nil {
-			fmt.Println(err.Error())
-			return
+		if err := flagCC(flCliCmd, flCliCEM, flCliCET, gvCliNames, transfer); err != nil {
+			flagErr(err)
+			return gvExitCode
 		}
 
-		return
+		return gvExitCode
 	}
 
 	// Default
 	flagHelp()
-	return
+	return gvExitCode
 }
 
 // flagVer displays the version information.
@@ -158,9 +228,18 @@ func flagVer() {
 func flagHelp() {
 
 	// Output
-	fmt.Print("Usage: yapi [OPTION]...\n\n")
+	fmt.Print("Usage: yapi [OPTION]...\n")
+	fmt.Print("       yapi [OPTION]... put LOCAL REMOTE\n")
+	fmt.Print("       yapi [OPTION]... get REMOTE LOCALDIR\n\n")
 	fmt.Printf("yapi - Yet Another Pipe Implementation - v%s\n", YAPI_VERSION)
 	fmt.Print(`
+  Commands:
+    put           : Upload the local file or directory (recursively) to the
+                    remote path on the clients. Modes and modification times
+                    are preserved.
+    get           : Download the remote file or directory (recursively) from
+                    the clients into LOCALDIR/CLIENTNAME directories.
+
   Options:
     -pc           : Pipe configuration file. Default; pipe.json
 
@@ -170,13 +249,100 @@ func flagHelp() {
     -cg           : Client group name(s) those will be connected.
                     Use comma (,) for multi-group.
     -ccem         : Execution method for client command. Default; serial
-                    Possible values; serial (~), parallel (//)
+                    Possible values; serial (~), parallel (//), rolling
+                    rolling; runs the clients in batches, waits for a batch
+                             before the next one and aborts the rollout
+                             when the failure threshold is reached
     -ccet         : Timeout (millisecond) for client command execution.
+                    SIGTERM is sent to the remote commands when it is reached.
+                    Ctrl-C (SIGINT), SIGTERM and SIGHUP are sent as well,
+                    a second Ctrl-C quits immediately.
+    -ccfl         : Fan-out limit for parallel client command execution.
+                    Maximum number of the clients those run at the same time.
+                    Default; 0 (no limit). serial method is same as -ccfl 1
+    -ccbs         : Batch size (N or N% of the clients) for rolling execution.
+                    Default; 1
+    -ccbp         : Pause (millisecond) between the batches of rolling execution.
+    -ccmf         : Failure threshold (N or N% of the clients) for client command
+                    execution. The execution is aborted when the number of the
+                    failed clients reaches the threshold; the remaining clients
+                    are skipped and the running ones are stopped (rolling method
+                    waits for the running batch). Default; no limit (rolling; 1)
+    -ccff         : Stop client command execution on the first failure.
+                    Same as -ccmf 1
+    -ccec         : Exit code scheme for failed client commands. Default; first
+                    Possible values; first, max, count
+                    first; exit code of the first failed client (in order)
+                    max  ; highest exit code of the failed clients
+                    count; number of the failed clients (up to 254)
+                    The exit code is 0 if all the client commands succeed.
+                    Clients which are skipped or stopped by -ccmf, -ccff
+                    are not counted. The exit code is 255 if there is no
+                    failed client but some are skipped (i.e. interrupt).
+                    The exit code of a client is 255 if the command
+                    couldn't be executed (connection error, timeout, etc.)
+                    and yapi exits with 255 due its own errors.
+    -ccof         : Output format for client commands. Default; raw
+                    Possible values; raw, prefix, group, json, ndjson
+                    raw   ; output of the clients as is
+                    prefix; every line is prefixed by the client name
+                            (stderr lines are marked by [stderr])
+                    group ; identical outputs (with the same status) are
+                            displayed once with the client names and the
+                            status (i.e. web[01-03,05] (exit code: 1))
+                    json  ; results of the clients (name, groups, address,
+                            kind, exit code, stdout, stderr, duration, error)
+                            and a summary as a JSON document
+                    ndjson; newline delimited JSON events for every output
+                            line, every client result and a final summary
+    -ccoc         : Colorize client names in the output.
+    -tty          : Request a pseudo-terminal for client commands (i.e. sudo,
+                    top). The local terminal is put into raw mode if there is
+                    only one client. stderr is merged into stdout.
+    -env          : Environment variable for client commands. Syntax: KEY=VALUE
+                    It can be used multiple times. Variables are sent by ssh
+                    (setenv) or exported by the command if the remote system
+                    refuses them (see AcceptEnv of sshd).
+    -cwd          : Working directory for client commands.
+    -sudo         : Execute client commands as root by sudo. The sudo password
+                    is asked once on the terminal (unless the become settings
+                    of the clients have it) and the prompt is hidden.
+    -become       : Execute client commands as the given user by sudo.
+    -script       : Local script file that will be executed on the clients.
+                    The script is uploaded into a temporary directory, executed
+                    by the interpreter of its shebang line (sh by default) and
+                    removed. The arguments are passed to the script.
+                    Syntax: -script FILE [ARG]... (use -- before the arguments
+                    which start with -)
 
     -ssh          : Simple SSH client command execution.
-                    It uses the current/given username and HOME/.ssh/id_rsa
-                    for the private key file.
+                    It uses the current/given username, ssh-agent (if
+                    SSH_AUTH_SOCK is set) and HOME/.ssh/id_ed25519,
+                    id_ecdsa, id_rsa for the private key files.
+                    Host aliases and settings of HOME/.ssh/config are used.
                     Syntax: [user@]host[:22]
+    -hostkey      : Host key policy for SSH clients. Default; strict
+                    Possible values; strict, accept-new, off
+                    strict    ; host key must be known (known_hosts files
+                                or hostKey option of the client)
+                    accept-new; unknown host keys are added to known_hosts
+                                file, changed host keys are refused
+                    off       ; no host key verification (insecure)
+    -hostca       : Host CA key for SSH host certificates. Public key or
+                    file of the public keys. Host certificates are
+                    verified by it instead of known_hosts files.
+    -J            : Jump hosts (ProxyJump) for SSH clients. The connections
+                    are tunneled through the jump hosts in order.
+                    Syntax: [user@]host[:22] or client name, comma separated
+    -ctimeout     : Connect timeout (millisecond) for SSH clients.
+                    Default; 10000 (negative value means no timeout)
+    -htimeout     : Handshake timeout (millisecond) for SSH clients.
+                    Default; 60000 (negative value means no timeout)
+    -keepalive    : Keepalive interval (millisecond) for SSH clients.
+                    Default; 0 (disabled)
+    -keepalivemax : Max number of unanswered keepalives for SSH
+                    clients. The connection is reported as lost when it
+                    is reached. Default; 3
 
     -h, -help     : Display help and exit.
     -v, -version  : Display version information and exit.
@@ -189,11 +355,29 @@ func flagHelp() {
     yapi -cc "tail -F /var/log/syslog" -ccem parallel
     yapi -cc hostname -cn "client1,client2" -ccem parallel
     yapi -cc hostname -cg group1 -ccem parallel
+    yapi -cc hostname -cg group1 -ccem parallel -ccfl 10
+    yapi -cc "service nginx restart" -cg group1 -ccem rolling -ccbs 25% -ccbp 5000
+    yapi -cc "apt-get -y upgrade" -cg group1 -ccem parallel -ccmf 10%
+    yapi -cc "uname -r" -cg group1 -ccem parallel -ccof group
+    yapi -cc "uname -r" -cg group1 -ccem parallel -ccof json
+    yapi -cc "tail -F /var/log/syslog" -cg group1 -ccem parallel -ccof prefix
     yapi -cc "ps aux" -cn client1 | yapi -cc "wc -l" -cn client2
+    yapi -cc top -cn client1 -tty
+    yapi -cc "make deploy" -cg group1 -env STAGE=prod -env DEBUG=1 -cwd /srv/app
+    yapi -cc "apt-get -y upgrade" -cg group1 -ccem parallel -sudo
+    yapi -cc "psql -c 'select 1'" -cn client1 -become postgres
+
+    yapi -script maintenance.sh -cg group1 -ccem parallel -- --dry-run
+    yapi put nginx.conf /etc/nginx/nginx.conf -cg group1 -ccem parallel
+    yapi get /var/log/nginx ./logs -cg group1 -ccem parallel
 
     yapi -ssh localhost -cc ls
     yapi -ssh user@localhost:22 -cc ls
     yapi -ssh host1,host2 -cc ls -ccem parallel
+    yapi -ssh newhost -cc ls -hostkey accept-new
+    yapi -ssh prod-db -cc uptime
+    yapi -ssh host1,host2 -cc ls -hostca ~/.ssh/host_ca.pub
+    yapi -ssh host1,host2 -cc ls -ccem parallel -J user@bastion
 
 
   Please report issues to https://github.com/cmfatih/yapi/issues
@@ -226,13 +410,29 @@ func flagDbg() {
 // flagPC loads pipe config.
 func flagPC(pcFile string) error {
 
-	if err := gvPipeConf.Load(pcFile, pipe.LoadOpt{CliInit: true}); err != nil {
+	if err := gvPipeConf.Load(pcFile, flagLoadOpt()); err != nil {
 		return errors.New("Error due pipe configuration: " + err.Error())
 	}
 
 	return nil
 }
 
+// flagLoadOpt returns the load options for the pipe configuration by the flags.
+func flagLoadOpt() pipe.LoadOpt {
+	return pipe.LoadOpt{
+		CliInit: true,
+		CliOpts: client.ClientOptions{
+			HostKeyPolicy:     flHostKey,
+			HostCAKey:         flHostCA,
+			ProxyJump:         flJump,
+			ConnectTimeout:    flConnTO,
+			HandshakeTimeout:  flHSTO,
+			KeepaliveInterval: flKAI,
+			KeepaliveCountMax: flKACM,
+		},
+	}
+}
+
 // flagCNG sets the client names and groups.
 func flagCNG(cliName, cliGroup string) {
 
@@ -254,8 +454,30 @@ func flagCNG(cliName, cliGroup string) {
 	return
 }
 
-// flagCC executes the client command.
-func flagCC(cliCmd, cliCmdEM string, cliCmdET int64, cliNames []string) error {
+// flagCC executes the client command or the file transfer (if any).
+func flagCC(cliCmd, cliCmdEM string, cliCmdET int64, cliNames []string, cliTransfer *worker.CCETransfer) error {
+
+	// Check the exit code scheme
+	if flCliCEC != "first" && flCliCEC != "max" && flCliCEC != "count" {
+		return errors.New("Invalid exit code scheme: " + flCliCEC)
+	}
+
+	// Check the script
+	var cliScriptArgs []string
+	if flScript != "" {
+		if cliCmd != "" || cliTransfer != nil {
+			return errors.New("-script can't be used with a client command or a file transfer")
+		} else if fInfo, err := os.Stat(flScript); err != nil || fInfo.Mode().IsRegular() == false {
+			return errors.New("Invalid script file: " + flScript)
+		}
+		cliScriptArgs = gvArgs
+	}
+
+	// Privilege escalation
+	cliBecome := flBecome
+	if cliBecome == "" && flSudo == true {
+		cliBecome = "root"
+	}
 
 	// Default client
 	if cliNames == nil {
@@ -276,9 +498,23 @@ func flagCC(cliCmd, cliCmdEM string, cliCmdET int64, cliNames []string) error {
 			Putty: worker.CCEOptions{
 				Clients:     cliNames,
 				Cmd:         cliCmd,
-				CmdErrPrint: true,
+				CmdErrPrint: flCliCOF != "json" && flCliCOF != "ndjson", // errors are part of JSON output
 				Method:      flagSymbolParser(cliCmdEM),
 				Timeout:     cliCmdET,
+				FanOut:      flCliCFL,
+				BatchSize:   flCliCBS,
+				BatchPause:  flCliCBP,
+				MaxFail:     flCliCMF,
+				FailFast:    flCliCFF,
+				Output:      flCliCOF,
+				Color:       flCliCOC,
+				TTY:         flTTY,
+				Env:         flEnv,
+				Dir:         flCwd,
+				Become:      cliBecome,
+				Transfer:    cliTransfer,
+				Script:      flScript,
+				ScriptArgs:  cliScriptArgs,
 			},
 		},
 	); err != nil {
@@ -286,15 +522,36 @@ func flagCC(cliCmd, cliCmdEM string, cliCmdET int64, cliNames []string) error {
 	}
 
 	// Start the worker
-	if err := ccew.Start(); err != nil {
+	// Interrupt, termination and hangup signals are delivered to the client commands.
+	// A second signal quits immediately.
+	ctx, interrupt := client.WithSignal(context.Background())
+	channSig := make(chan os.Signal, 2)
+	signal.Notify(channSig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(channSig)
+
+	go func() {
+		sig := <-channSig
+		fmt.Fprintln(os.Stderr, "interrupted, stopping the client commands (press Ctrl-C again to force quit)")
+		interrupt(sig)
+
+		<-channSig
+		client.TTYRestore() // raw mode of the pseudo-terminal
+		fmt.Fprintln(os.Stderr, "force quit")
+		os.Exit(130)
+	}()
+
+	if err := ccew.Start(ctx); err != nil {
+		if _, ok := err.(*worker.CCEError); ok {
+			return err
+		}
 		return errors.New("Failed to execute the command: " + err.Error())
 	}
 
 	return nil
 }
 
-// flagSSH executes the given command via ssh client.
-func flagSSH(sshOpt, cliCmd, cliCmdEM string, cliCmdET int64) error {
+// flagSSH executes the given command or file transfer via ssh client.
+func flagSSH(sshOpt, cliCmd, cliCmdEM string, cliCmdET int64, cliTransfer *worker.CCETransfer) error {
 
 	// Init vars
 	cliAddrs := flagMultiParser(sshOpt, ",")
@@ -304,8 +561,8 @@ func flagSSH(sshOpt, cliCmd, cliCmdEM string, cliCmdET int64) error {
 	for key, val := range cliAddrs {
 		name := fmt.Sprintf("ssh_%d", key)
 		addr := val
-		authUN := "" // default; current user
-		authKf := gvHOME + "/.ssh/id_rsa"
+		authUN := ""                               // default; current user
+		authAg := os.Getenv("SSH_AUTH_SOCK") != "" // ssh-agent first if it is available
 
 		if strings.Contains(val, "@") == true {
 			spl := strings.SplitN(val, "@", 2)
@@ -322,8 +579,9 @@ func flagSSH(sshOpt, cliCmd, cliCmdEM string, cliCmdET int64) error {
 			"isDefault": true,
 			"address":   addr,
 			"auth": map[string]interface{}{
-				"username": authUN,
-				"keyfile":  authKf,
+				"username":        authUN,
+				"agent":           authAg,
+				"defaultKeyfiles": true, // after IdentityFile of ssh_config
 			},
 		}
 		if buf, err := json.Marshal(jc); err != nil {
@@ -336,17 +594,103 @@ func flagSSH(sshOpt, cliCmd, cliCmdEM string, cliCmdET int64) error {
 
 	jsonCont := "{\"Clients\":[" + strings.Join(cliConfs, ",") + "]}"
 
-	if err := gvPipeConf.LoadJSON(jsonCont, pipe.LoadOpt{CliInit: true}); err != nil {
+	if err := gvPipeConf.LoadJSON(jsonCont, flagLoadOpt()); err != nil {
 		return errors.New("Error due pipe configuration: " + err.Error())
 	}
 
-	if err := flagCC(cliCmd, cliCmdEM, cliCmdET, cliNames); err != nil {
+	if err := flagCC(cliCmd, cliCmdEM, cliCmdET, cliNames, cliTransfer); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// flagErr displays the given error (on stderr) and sets the exit code.
+// Failed client commands (*worker.CCEError) are not displayed since
+// the client errors are already displayed by the worker.
+func flagErr(err error) {
+
+	if cceErr, ok := err.(*worker.CCEError); ok {
+		gvExitCode = flagExitCode(cceErr, flCliCEC)
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, err.Error())
+	gvExitCode = 255
+}
+
+// flagExitCode returns the exit code by the given CCE error and exit code scheme.
+// Possible schemes; first, max, count
+// Stopped and skipped client commands are not failures. If there is no failure
+// then the execution is incomplete (i.e. interrupted) and the exit code is 255.
+func flagExitCode(cceErr *worker.CCEError, scheme string) int {
+
+	if len(cceErr.Results) == 0 {
+		return 255
+	}
+
+	code := 0
+	for i, res := range cceErr.Results {
+
+		// Unknown exit code
+		resCode := res.ExitCode
+		if resCode < 0 || resCode > 255 {
+			resCode = 255
+		}
+
+		if scheme == "first" && i == 0 {
+			code = resCode
+		} else if scheme == "max" && resCode > code {
+			code = resCode
+		} else if scheme == "count" {
+			code = i + 1
+		}
+	}
+
+	if scheme == "count" && code > 254 {
+		code = 254
+	}
+
+	return code
+}
+
+// flagParse parses the command line flags and returns the other arguments.
+// Flags can be placed before or after the arguments (i.e. `put LOCAL REMOTE -cg group1`)
+// until `--`.
+func flagParse() []string {
+
+	var args []string
+	rest := os.Args[1:]
+	for {
+		flag.CommandLine.Parse(rest)
+		isEnd := len(rest) > len(flag.Args()) && rest[len(rest)-len(flag.Args())-1] == "--"
+		rest = flag.Args()
+		if isEnd == true {
+			return append(args, rest...) // `--` ends the flags
+		} else if len(rest) == 0 {
+			break
+		}
+		args = append(args, rest[0])
+		rest = rest[1:]
+	}
+
+	return args
+}
+
+// flagList implements a flag which can be used multiple times.
+type flagList []string
+
+// String returns the values of the flag.
+func (fl *flagList) String() string {
+	return strings.Join(*fl, ",")
+}
+
+// Set adds the given value to the flag.
+func (fl *flagList) Set(val string) error {
+	*fl = append(*fl, val)
+	return nil
+}
+
 // flagMultiParser parses multiple flag value.
 func flagMultiParser(flagVal, valSep string) []string {
 
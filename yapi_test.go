@@ -0,0 +1,57 @@
+// yapi
+// Copyright (c) 2014 Fatih Cetinkaya (http://github.com/cmfatih/yapi)
+// For the full copyright and license information, please view the LICENSE.txt file.
+
+package main
+
+import (
+	"github.com/cmfatih/yapi/client"
+	"github.com/cmfatih/yapi/worker"
+	"testing"
+)
+
+// TestFlagExitCode checks the exit codes of the exit code schemes.
+func TestFlagExitCode(t *testing.T) {
+
+	// Results of the failed clients
+	results := func(codes ...int) *worker.CCEError {
+		cceErr := &worker.CCEError{}
+		for _, code := range codes {
+			cceErr.Results = append(cceErr.Results, client.Result{ExitCode: code})
+		}
+		return cceErr
+	}
+	many := make([]int, 300)
+	for i := range many {
+		many[i] = 1
+	}
+
+	tests := []struct {
+		scheme string
+		codes  []int
+		want   int
+	}{
+		{"first", []int{3, 7, 1}, 3},
+		{"first", []int{-1, 7}, 255}, // unknown exit code (i.e. connection error)
+		{"first", []int{300}, 255},
+		{"first", nil, 255}, // incomplete execution without failures (i.e. interrupt)
+		{"max", []int{3, 7, 1}, 7},
+		{"max", []int{3, -1, 1}, 255},
+		{"max", []int{2}, 2},
+		{"max", nil, 255},
+		{"count", []int{3, 7, 1}, 3},
+		{"count", []int{-1}, 1},
+		{"count", many, 254},
+		{"count", nil, 255},
+		// Aborted executions (the stopped and skipped clients are not in the results,
+		// see worker.cceResultsErr), so the client which triggers the abort decides
+		{"first", []int{3}, 3},
+		{"count", []int{3}, 1},
+	}
+
+	for _, test := range tests {
+		if got := flagExitCode(results(test.codes...), test.scheme); got != test.want {
+			t.Errorf("%s %v: got %d, want %d", test.scheme, test.codes, got, test.want)
+		}
+	}
+}